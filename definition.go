@@ -0,0 +1,527 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	Definition struct {
+		Name    string           `json:"name" yaml:"name"`
+		Strict  bool             `json:"strict,omitempty" yaml:"strict,omitempty"`
+		Timeout string           `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+		Nodes   []NodeDefinition `json:"nodes" yaml:"nodes"`
+		Edges   []EdgeDefinition `json:"edges" yaml:"edges"`
+	}
+
+	NodeDefinition struct {
+		Key  string `json:"key" yaml:"key"`
+		Type string `json:"type,omitempty" yaml:"type,omitempty"`
+		line int
+	}
+
+	EdgeDefinition struct {
+		Type          string            `json:"type,omitempty" yaml:"type,omitempty"`
+		From          string            `json:"from" yaml:"from"`
+		To            string            `json:"to,omitempty" yaml:"to,omitempty"`
+		Condition     string            `json:"condition,omitempty" yaml:"condition,omitempty"`
+		True          string            `json:"true,omitempty" yaml:"true,omitempty"`
+		False         string            `json:"false,omitempty" yaml:"false,omitempty"`
+		Aggregate     string            `json:"aggregate,omitempty" yaml:"aggregate,omitempty"`
+		Parallels     []string          `json:"parallels,omitempty" yaml:"parallels,omitempty"`
+		OnError       string            `json:"on_error,omitempty" yaml:"on_error,omitempty"`
+		Join          string            `json:"join,omitempty" yaml:"join,omitempty"`
+		Quorum        int               `json:"quorum,omitempty" yaml:"quorum,omitempty"`
+		Cases         map[string]string `json:"cases,omitempty" yaml:"cases,omitempty"`
+		Default       string            `json:"default,omitempty" yaml:"default,omitempty"`
+		Body          string            `json:"body,omitempty" yaml:"body,omitempty"`
+		MaxIterations int               `json:"max_iterations,omitempty" yaml:"max_iterations,omitempty"`
+		Splitter      string            `json:"splitter,omitempty" yaml:"splitter,omitempty"`
+		Mapper        string            `json:"mapper,omitempty" yaml:"mapper,omitempty"`
+		Reducer       string            `json:"reducer,omitempty" yaml:"reducer,omitempty"`
+		Concurrency   int               `json:"concurrency,omitempty" yaml:"concurrency,omitempty"`
+		line          int
+	}
+
+	Registry struct {
+		lock    *sync.RWMutex
+		actions map[string]registeredAction
+	}
+
+	registeredAction struct {
+		action   contextAction
+		workflow string
+		storage  Storage
+		options  []NodeOption
+	}
+
+	DefinitionError struct {
+		Line int
+		Err  error
+	}
+)
+
+const (
+	SequentialEdge     = "sequential"
+	ConditionalEdge    = "conditional"
+	ParallelEdge       = "parallel"
+	SwitchEdge         = "switch"
+	ConditionErrorEdge = "condition_error"
+	WhileEdge          = "while"
+	DoUntilEdge        = "do_until"
+	MapEdge            = "map"
+	ErrorEdge          = "error"
+	ErrorRegionEdge    = "error_region"
+)
+
+var errorModes = map[string]ErrorMode{
+	"":          FailFast,
+	"fail_fast": FailFast,
+	"wait_all":  WaitAll,
+	"continue":  ContinueOnError,
+}
+
+var joinStrategies = map[string]JoinStrategy{
+	"":            JoinAll,
+	"all":         JoinAll,
+	"first":       JoinFirst,
+	"quorum":      JoinQuorum,
+	"all_settled": JoinAllSettled,
+}
+
+func (j JoinStrategy) String() string {
+	switch j {
+	case JoinFirst:
+		return "first"
+	case JoinQuorum:
+		return "quorum"
+	case JoinAllSettled:
+		return "all_settled"
+	}
+
+	return "all"
+}
+
+func (m ErrorMode) String() string {
+	switch m {
+	case WaitAll:
+		return "wait_all"
+	case ContinueOnError:
+		return "continue"
+	}
+
+	return "fail_fast"
+}
+
+func (e *DefinitionError) Error() string {
+	if e.Line == 0 {
+		return e.Err.Error()
+	}
+
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err.Error())
+}
+
+func (e *DefinitionError) Unwrap() error {
+	return e.Err
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		lock:    &sync.RWMutex{},
+		actions: make(map[string]registeredAction),
+	}
+}
+
+func (r *Registry) Register(name string, param action, options ...NodeOption) {
+	r.RegisterWithContext(name, func(_ context.Context, p map[string][]byte) ([]byte, error) {
+		return param(p)
+	}, options...)
+}
+
+func (r *Registry) RegisterWithContext(name string, param contextAction, options ...NodeOption) {
+	r.lock.Lock()
+	r.actions[name] = registeredAction{action: param, options: options}
+	r.lock.Unlock()
+}
+
+func (r *Registry) RegisterWorkflow(name string, workflow string, storage Storage, options ...NodeOption) {
+	r.lock.Lock()
+	r.actions[name] = registeredAction{workflow: workflow, storage: storage, options: options}
+	r.lock.Unlock()
+}
+
+func (r *Registry) NewNode(key string, name string) (*Node, error) {
+	r.lock.RLock()
+	a, ok := r.actions[name]
+	r.lock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("node type '%s' is not registered", name)
+	}
+
+	if a.storage != nil {
+		n := NewWorkflowNode(key, a.workflow, a.storage, a.options...)
+		n.typ = name
+
+		return n, nil
+	}
+
+	n := NewNodeWithContext(key, a.action, a.options...)
+	n.typ = name
+
+	return n, nil
+}
+
+func LoadFile(path string, registry *Registry, options ...WorkflowOption) (*Workflow, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Load(data, registry, options...)
+}
+
+func Load(data []byte, registry *Registry, options ...WorkflowOption) (*Workflow, error) {
+	definition, err := ParseDefinition(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return registry.Build(definition, options...)
+}
+
+func ParseDefinition(data []byte) (Definition, error) {
+	definition := Definition{}
+
+	document := yaml.Node{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return definition, err
+	}
+
+	if len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+		return definition, errors.New("workflow definition must be an object")
+	}
+
+	root := document.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch key.Value {
+		case "name":
+			if err := value.Decode(&definition.Name); err != nil {
+				return definition, &DefinitionError{Line: value.Line, Err: err}
+			}
+		case "strict":
+			if err := value.Decode(&definition.Strict); err != nil {
+				return definition, &DefinitionError{Line: value.Line, Err: err}
+			}
+		case "timeout":
+			if err := value.Decode(&definition.Timeout); err != nil {
+				return definition, &DefinitionError{Line: value.Line, Err: err}
+			}
+
+			if _, err := parseTimeout(definition.Timeout); err != nil {
+				return definition, &DefinitionError{Line: value.Line, Err: err}
+			}
+		case "nodes":
+			if value.Kind != yaml.SequenceNode {
+				return definition, &DefinitionError{Line: value.Line, Err: errors.New("nodes must be a list")}
+			}
+
+			for _, item := range value.Content {
+				n := NodeDefinition{line: item.Line}
+				if err := checkFields(item, n); err != nil {
+					return definition, err
+				}
+
+				if err := item.Decode(&n); err != nil {
+					return definition, &DefinitionError{Line: item.Line, Err: err}
+				}
+
+				definition.Nodes = append(definition.Nodes, n)
+			}
+		case "edges":
+			if value.Kind != yaml.SequenceNode {
+				return definition, &DefinitionError{Line: value.Line, Err: errors.New("edges must be a list")}
+			}
+
+			for _, item := range value.Content {
+				e := EdgeDefinition{line: item.Line}
+				if err := checkFields(item, e); err != nil {
+					return definition, err
+				}
+
+				if err := item.Decode(&e); err != nil {
+					return definition, &DefinitionError{Line: item.Line, Err: err}
+				}
+
+				definition.Edges = append(definition.Edges, e)
+			}
+		default:
+			return definition, &DefinitionError{Line: key.Line, Err: fmt.Errorf("unknown field '%s'", key.Value)}
+		}
+	}
+
+	if definition.Name == "" {
+		return definition, &DefinitionError{Line: root.Line, Err: errors.New("workflow name is required")}
+	}
+
+	return definition, nil
+}
+
+func checkFields(item *yaml.Node, v any) error {
+	if item.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	fields := make(map[string]bool)
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+
+	for i := 0; i+1 < len(item.Content); i += 2 {
+		if key := item.Content[i]; !fields[key.Value] {
+			return &DefinitionError{Line: key.Line, Err: fmt.Errorf("unknown field '%s'", key.Value)}
+		}
+	}
+
+	return nil
+}
+
+func (r *Registry) Build(definition Definition, options ...WorkflowOption) (*Workflow, error) {
+	if definition.Name == "" {
+		return nil, &DefinitionError{Err: errors.New("workflow name is required")}
+	}
+
+	if definition.Strict {
+		options = append(options, WithStrictConditions())
+	}
+
+	if definition.Timeout != "" {
+		timeout, err := parseTimeout(definition.Timeout)
+		if err != nil {
+			return nil, &DefinitionError{Err: err}
+		}
+
+		options = append(options, WithTimeout(timeout))
+	}
+
+	w := NewWorkflow(definition.Name, options...)
+	for _, d := range definition.Nodes {
+		if d.Key == "" {
+			return nil, &DefinitionError{Line: d.line, Err: errors.New("node key is required")}
+		}
+
+		if _, exists := w.availableNodes[d.Key]; exists {
+			return nil, &DefinitionError{Line: d.line, Err: fmt.Errorf("node '%s' is defined more than once", d.Key)}
+		}
+
+		name := d.Type
+		if name == "" {
+			name = d.Key
+		}
+
+		n, err := r.NewNode(d.Key, name)
+		if err != nil {
+			return nil, &DefinitionError{Line: d.line, Err: err}
+		}
+
+		w.AddNode(n)
+	}
+
+	for _, e := range definition.Edges {
+		if err := w.addDefinedEdge(e); err != nil {
+			return nil, &DefinitionError{Line: e.line, Err: err}
+		}
+	}
+
+	return w, nil
+}
+
+func parseTimeout(value string) (time.Duration, error) {
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid timeout '%s'", value)
+	}
+
+	return timeout, nil
+}
+
+// Definition only carries the workflow structure and workflow options. Node
+// policies such as WithRetry, WithNodeTimeout and WithCompensation are not
+// serialized: a rebuilt workflow takes them from the options registered for
+// each node type in the Registry, so options passed to NewNode are lost.
+func (w *Workflow) Definition() Definition {
+	w.cLock.Lock()
+	defer w.cLock.Unlock()
+
+	definition := Definition{
+		Name:   w.key,
+		Strict: w.strict,
+		Nodes:  make([]NodeDefinition, 0, len(w.availableNodes)),
+		Edges:  make([]EdgeDefinition, len(w.edges)),
+	}
+
+	if w.timeout > 0 {
+		definition.Timeout = w.timeout.String()
+	}
+
+	for _, n := range w.availableNodes {
+		d := NodeDefinition{Key: n.key}
+		if n.typ != n.key {
+			d.Type = n.typ
+		}
+
+		definition.Nodes = append(definition.Nodes, d)
+	}
+	sort.Slice(definition.Nodes, func(i, j int) bool {
+		return definition.Nodes[i].Key < definition.Nodes[j].Key
+	})
+
+	copy(definition.Edges, w.edges)
+
+	return definition
+}
+
+func (w *Workflow) addDefinedEdge(e EdgeDefinition) error {
+	from, err := w.lookup(e.From)
+	if err != nil {
+		return err
+	}
+
+	switch e.Type {
+	case "", SequentialEdge:
+		to, err := w.lookup(e.To)
+		if err != nil {
+			return err
+		}
+
+		return w.AddEdge(from[0], to[0])
+	case ConditionalEdge:
+		nodes, err := w.lookup(e.Condition, e.True, e.False)
+		if err != nil {
+			return err
+		}
+
+		return w.AddConditionalEdge(from[0], nodes[0], nodes[1], nodes[2])
+	case ConditionErrorEdge:
+		to, err := w.lookup(e.To)
+		if err != nil {
+			return err
+		}
+
+		return w.AddConditionErrorEdge(from[0], to[0])
+	case ErrorEdge, ErrorRegionEdge:
+		to, err := w.lookup(e.To)
+		if err != nil {
+			return err
+		}
+
+		if e.Type == ErrorRegionEdge {
+			return w.AddErrorRegionEdge(from[0], to[0])
+		}
+
+		return w.AddErrorEdge(from[0], to[0])
+	case WhileEdge, DoUntilEdge:
+		nodes, err := w.lookup(e.Condition, e.Body)
+		if err != nil {
+			return err
+		}
+
+		if e.Type == DoUntilEdge {
+			return w.AddDoUntilEdge(from[0], nodes[0], nodes[1], e.MaxIterations)
+		}
+
+		return w.AddWhileEdge(from[0], nodes[0], nodes[1], e.MaxIterations)
+	case MapEdge:
+		nodes, err := w.lookup(e.Splitter, e.Mapper, e.Reducer)
+		if err != nil {
+			return err
+		}
+
+		return w.AddMapEdge(from[0], nodes[0], nodes[1], nodes[2], e.Concurrency)
+	case SwitchEdge:
+		condition, err := w.lookup(e.Condition, e.Default)
+		if err != nil {
+			return err
+		}
+
+		cases := make(map[string]*Node, len(e.Cases))
+		for value, key := range e.Cases {
+			n, err := w.lookup(key)
+			if err != nil {
+				return err
+			}
+
+			cases[value] = n[0]
+		}
+
+		return w.AddSwitchEdge(from[0], condition[0], cases, condition[1])
+	case ParallelEdge:
+		aggregate, err := w.lookup(e.Aggregate)
+		if err != nil {
+			return err
+		}
+
+		if len(e.Parallels) == 0 {
+			return errors.New("parallel edge requires at least one parallel node")
+		}
+
+		parallels, err := w.lookup(e.Parallels...)
+		if err != nil {
+			return err
+		}
+
+		mode, ok := errorModes[e.OnError]
+		if !ok {
+			return fmt.Errorf("unknown error mode '%s'", e.OnError)
+		}
+
+		join, ok := joinStrategies[e.Join]
+		if !ok {
+			return fmt.Errorf("unknown join strategy '%s'", e.Join)
+		}
+
+		return w.AddParallelEdgeWithPolicy(from[0], aggregate[0], ParallelPolicy{OnError: mode, Join: join, Quorum: e.Quorum}, parallels...)
+	}
+
+	return fmt.Errorf("unknown edge type '%s'", e.Type)
+}
+
+func (w *Workflow) lookup(keys ...string) ([]*Node, error) {
+	nodes := make([]*Node, 0, len(keys))
+	for _, k := range keys {
+		if k == "" {
+			return nil, errors.New("edge references an empty node key")
+		}
+
+		n, ok := w.availableNodes[k]
+		if !ok {
+			return nil, fmt.Errorf("node '%s' is not defined", k)
+		}
+
+		nodes = append(nodes, n)
+	}
+
+	return nodes, nil
+}
+
+func keys(nodes []*Node) []string {
+	result := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		result = append(result, n.key)
+	}
+
+	return result
+}
@@ -0,0 +1,226 @@
+package flow
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestRegistry() *Registry {
+	registry := NewRegistry()
+	registry.Register("echo", func(param map[string][]byte) ([]byte, error) {
+		return param["data"], nil
+	})
+	registry.Register("fail", func(param map[string][]byte) ([]byte, error) {
+		return nil, errors.New("failed")
+	})
+	registry.Register("handle", func(param map[string][]byte) ([]byte, error) {
+		return []byte("handled " + string(param["node"])), nil
+	})
+
+	return registry
+}
+
+func TestParseDefinition(t *testing.T) {
+	cases := []struct {
+		name     string
+		data     string
+		wantLine int
+		wantErr  string
+	}{
+		{
+			name: "valid yaml",
+			data: "name: w\nnodes:\n  - key: a\n    type: echo\n  - key: b\n    type: echo\nedges:\n  - from: a\n    to: b\n",
+		},
+		{
+			name: "valid json",
+			data: `{"name":"w","nodes":[{"key":"a","type":"echo"},{"key":"b","type":"echo"}],"edges":[{"from":"a","to":"b"}]}`,
+		},
+		{
+			name:     "missing name",
+			data:     "nodes: []\n",
+			wantLine: 1,
+			wantErr:  "workflow name is required",
+		},
+		{
+			name:     "unknown root field",
+			data:     "name: w\nnode: []\n",
+			wantLine: 2,
+			wantErr:  "unknown field 'node'",
+		},
+		{
+			name:     "unknown node field",
+			data:     "name: w\nnodes:\n  - key: a\n    typ: echo\n",
+			wantLine: 4,
+			wantErr:  "unknown field 'typ'",
+		},
+		{
+			name:     "unknown edge field",
+			data:     "name: w\nnodes:\n  - key: a\n  - key: b\nedges:\n  - from: a\n    too: b\n",
+			wantLine: 7,
+			wantErr:  "unknown field 'too'",
+		},
+		{
+			name:     "invalid timeout",
+			data:     "name: w\ntimeout: soon\n",
+			wantLine: 2,
+			wantErr:  "invalid timeout 'soon'",
+		},
+		{
+			name:     "nodes not a list",
+			data:     "name: w\nnodes: a\n",
+			wantLine: 2,
+			wantErr:  "nodes must be a list",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := ParseDefinition([]byte(c.data))
+			if c.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return
+			}
+
+			definitionErr := &DefinitionError{}
+			if !errors.As(err, &definitionErr) {
+				t.Fatalf("expected definition error, got %v", err)
+			}
+
+			if definitionErr.Line != c.wantLine || !strings.Contains(err.Error(), c.wantErr) {
+				t.Fatalf("expected %q at line %d, got %q at line %d", c.wantErr, c.wantLine, err.Error(), definitionErr.Line)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	cases := []struct {
+		name    string
+		data    string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "sequential",
+			data: "name: w\nnodes:\n  - key: a\n    type: echo\n  - key: b\n    type: echo\nedges:\n  - from: a\n    to: b\n",
+			want: "x",
+		},
+		{
+			name: "error edge on leaf node",
+			data: "name: w\nnodes:\n  - key: a\n    type: echo\n  - key: b\n    type: fail\n  - key: h\n    type: handle\nedges:\n  - from: a\n    to: b\n  - type: error\n    from: b\n    to: h\n",
+			want: "handled b",
+		},
+		{
+			name: "error region edge",
+			data: "name: w\nnodes:\n  - key: a\n    type: echo\n  - key: b\n    type: fail\n  - key: h\n    type: handle\nedges:\n  - type: error_region\n    from: a\n    to: h\n  - from: a\n    to: b\n",
+			want: "handled b",
+		},
+		{
+			name:    "unregistered action",
+			data:    "name: w\nnodes:\n  - key: a\n    type: missing\n",
+			wantErr: "line 3",
+		},
+		{
+			name:    "unknown node in edge",
+			data:    "name: w\nnodes:\n  - key: a\n    type: echo\nedges:\n  - from: a\n    to: b\n",
+			wantErr: "line 6",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			w, err := Load([]byte(c.data), newTestRegistry(), WithLogger(discard), WithMetrics(NewMetrics(nil)))
+			if c.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", c.wantErr, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := w.Execute([]byte("x"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(res) != c.want {
+				t.Fatalf("expected %q, got %q", c.want, res)
+			}
+		})
+	}
+}
+
+func TestDefinitionRoundTrip(t *testing.T) {
+	data := "name: w\nstrict: true\ntimeout: 1m30s\nnodes:\n  - key: a\n    type: echo\n  - key: b\n    type: echo\n  - key: c\n    type: echo\n  - key: agg\n    type: echo\n  - key: h\n    type: handle\nedges:\n  - type: parallel\n    from: a\n    aggregate: agg\n    parallels: [b, c]\n    on_error: wait_all\n    join: quorum\n    quorum: 1\n  - type: error\n    from: agg\n    to: h\n"
+
+	w, err := Load([]byte(data), newTestRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.timeout != 90*time.Second || !w.strict {
+		t.Fatalf("workflow options not loaded: timeout %s, strict %t", w.timeout, w.strict)
+	}
+
+	rebuilt, err := newTestRegistry().Build(w.Definition())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(w.Definition(), rebuilt.Definition()) {
+		t.Fatalf("definition changed after round trip:\n%+v\n%+v", w.Definition(), rebuilt.Definition())
+	}
+}
+
+func TestDefinitionNodePolicies(t *testing.T) {
+	registry := newTestRegistry()
+	registry.Register("flaky", func(param map[string][]byte) ([]byte, error) {
+		return param["data"], nil
+	}, WithRetry(RetryPolicy{MaxAttempts: 3}))
+
+	action := func(param map[string][]byte) ([]byte, error) {
+		return param["data"], nil
+	}
+
+	w := NewWorkflow("w", WithTimeout(time.Minute))
+	a := NewNode("a", action, WithNodeTimeout(time.Second))
+	b := NewNode("b", action, WithRetry(RetryPolicy{MaxAttempts: 5}))
+	b.typ = "flaky"
+	w.AddNode(a, b)
+	if err := w.AddEdge(a, b); err != nil {
+		t.Fatal(err)
+	}
+
+	registry.Register("a", action)
+	definition := w.Definition()
+	if definition.Timeout != "1m0s" {
+		t.Fatalf("expected workflow timeout to be serialized, got %q", definition.Timeout)
+	}
+
+	rebuilt, err := registry.Build(definition)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rebuilt.timeout != time.Minute {
+		t.Fatalf("expected workflow timeout to be restored, got %s", rebuilt.timeout)
+	}
+
+	if n := rebuilt.availableNodes["a"]; n.timeout != 0 {
+		t.Fatalf("expected node timeout to come from the registry, got %s", n.timeout)
+	}
+
+	if n := rebuilt.availableNodes["b"]; n.retry.MaxAttempts != 3 {
+		t.Fatalf("expected retry policy to come from the registry, got %+v", n.retry)
+	}
+}
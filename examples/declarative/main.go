@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ad3n/flow-graph"
+)
+
+func main() {
+	registry := flow.NewRegistry()
+	registry.Register("get-input", func(param map[string][]byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("%s get-input", param["data"])), nil
+	})
+	registry.Register("transform-user", func(param map[string][]byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("%s transform-user", param["data"])), nil
+	})
+	registry.Register("validate-user", func(param map[string][]byte) ([]byte, error) {
+		return []byte("true"), nil
+	})
+	registry.Register("save-user", func(param map[string][]byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("%s save-user", param["data"])), nil
+	})
+	registry.Register("error-response", func(param map[string][]byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("%s error-response", param["data"])), nil
+	})
+	registry.Register("send-response", func(param map[string][]byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("%s send-response", param["data"])), nil
+	})
+
+	workflow, err := flow.LoadFile("add-user.yaml", registry)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	result, _ := workflow.Execute([]byte("hallo"))
+
+	fmt.Println(string(result))
+}
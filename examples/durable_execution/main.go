@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/ad3n/flow-graph"
+)
+
+func main() {
+	dir, err := os.MkdirTemp("", "runs")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := flow.NewFileRunStore(dir)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	available := false
+
+	node1 := flow.NewNode("Get Input", func(param map[string][]byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("%s node1", param["data"])), nil
+	})
+	node2 := flow.NewNode("Save User", func(param map[string][]byte) ([]byte, error) {
+		if !available {
+			return nil, errors.New("database is not available")
+		}
+
+		return []byte(fmt.Sprintf("%s node2", param["data"])), nil
+	})
+	node3 := flow.NewNode("Send Response", func(param map[string][]byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("%s node3", param["data"])), nil
+	})
+
+	workflow := flow.NewWorkflow("Add User", flow.WithRunStore(store))
+	workflow.AddNode(node1, node2, node3)
+	if err := workflow.AddEdge(node1, node2); err != nil {
+		log.Fatalln(err)
+	}
+
+	if err := workflow.AddEdge(node2, node3); err != nil {
+		log.Fatalln(err)
+	}
+
+	_, err = workflow.ExecuteRun(context.Background(), "add-user-1", []byte("hallo"))
+	fmt.Println(err)
+
+	available = true
+
+	result, _ := workflow.Resume(context.Background(), "add-user-1")
+
+	fmt.Println(string(result))
+}
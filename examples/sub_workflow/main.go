@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ad3n/flow-graph"
+)
+
+func main() {
+	storage := flow.NewInMemoryStorage()
+
+	node1 := flow.NewNode("Send Email", func(param map[string][]byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("%s node1", param["data"])), nil
+	})
+	node2 := flow.NewNode("Send Sms", func(param map[string][]byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("%s node2", param["data"])), nil
+	})
+
+	notify := flow.NewWorkflow("Notify User")
+	notify.AddNode(node1, node2)
+	if err := notify.AddEdge(node1, node2); err != nil {
+		log.Fatalln(err)
+	}
+
+	storage.Save(notify)
+
+	node3 := flow.NewNode("Save User", func(param map[string][]byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("%s node3", param["data"])), nil
+	})
+	node4 := flow.NewWorkflowNode("Notify", notify.GetName(), storage)
+	node5 := flow.NewNode("Send Response", func(param map[string][]byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("%s node5", param["data"])), nil
+	})
+
+	workflow := flow.NewWorkflow("Add User")
+	workflow.AddNode(node3, node4, node5)
+	if err := workflow.AddEdge(node3, node4); err != nil {
+		log.Fatalln(err)
+	}
+
+	if err := workflow.AddEdge(node4, node5); err != nil {
+		log.Fatalln(err)
+	}
+
+	result, _ := workflow.Execute([]byte("hallo"))
+
+	fmt.Println(string(result))
+
+	dot, _ := workflow.Export(flow.ExpandWorkflows())
+
+	fmt.Println(string(dot))
+}
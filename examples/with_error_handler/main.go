@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/ad3n/flow-graph"
+)
+
+func main() {
+	node1 := flow.NewNode("Get Input", func(param map[string][]byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("%s node1", param["data"])), nil
+	})
+	node2 := flow.NewNode("Validate Input", func(param map[string][]byte) ([]byte, error) {
+		return nil, errors.New("input is not valid")
+	})
+	node3 := flow.NewNode("Save Input", func(param map[string][]byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("%s node3", param["data"])), nil
+	})
+	node4 := flow.NewNode("Error Response", func(param map[string][]byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("%s failed: %s", param["node"], param["error"])), nil
+	})
+
+	workflow := flow.NewWorkflow("Store Input")
+	workflow.AddNode(node1, node2, node3, node4)
+	if err := workflow.AddEdge(node1, node2); err != nil {
+		log.Fatalln(err)
+	}
+
+	if err := workflow.AddEdge(node2, node3); err != nil {
+		log.Fatalln(err)
+	}
+
+	if err := workflow.AddErrorRegionEdge(node1, node4); err != nil {
+		log.Fatalln(err)
+	}
+
+	result, _ := workflow.Execute([]byte("hallo"))
+
+	fmt.Println(string(result))
+}
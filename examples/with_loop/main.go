@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/ad3n/flow-graph"
+)
+
+func main() {
+	node1 := flow.NewNode("Submit Job", func(param map[string][]byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("%s node1", param["data"])), nil
+	})
+	node2 := flow.NewNode("Poll Status", func(param map[string][]byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("%s poll", param["data"])), nil
+	})
+	node3 := flow.NewNode("Is Ready", func(param map[string][]byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("%t", strings.Count(string(param["data"]), "poll") >= 3)), nil
+	})
+	node4 := flow.NewNode("Send Response", func(param map[string][]byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("%s node4", param["data"])), nil
+	})
+
+	workflow := flow.NewWorkflow("Wait For Job")
+	workflow.AddNode(node1, node2, node3, node4)
+	if err := workflow.AddDoUntilEdge(node1, node3, node2, 5); err != nil {
+		log.Fatalln(err)
+	}
+
+	if err := workflow.AddEdge(node3, node4); err != nil {
+		log.Fatalln(err)
+	}
+
+	result, _ := workflow.Execute([]byte("hallo"))
+
+	fmt.Println(string(result))
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ad3n/flow-graph"
+)
+
+func main() {
+	node1 := flow.NewNode("Get Input", func(param map[string][]byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("%s node1", param["data"])), nil
+	})
+	node2 := flow.NewNode("Route Notification", func(param map[string][]byte) ([]byte, error) {
+		return []byte("email"), nil
+	})
+	node3 := flow.NewNode("Send Email", func(param map[string][]byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("%s node3", param["data"])), nil
+	})
+	node4 := flow.NewNode("Send Sms", func(param map[string][]byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("%s node4", param["data"])), nil
+	})
+	node5 := flow.NewNode("Send Push", func(param map[string][]byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("%s node5", param["data"])), nil
+	})
+	node6 := flow.NewNode("Unknown Channel", func(param map[string][]byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("%s node6", param["data"])), nil
+	})
+
+	workflow := flow.NewWorkflow("Send Notification")
+	workflow.AddNode(node1, node2, node3, node4, node5, node6)
+	if err := workflow.AddSwitchEdge(node1, node2, map[string]*flow.Node{
+		"email": node3,
+		"sms":   node4,
+		"push":  node5,
+	}, node6); err != nil {
+		log.Fatalln(err)
+	}
+
+	result, _ := workflow.Execute([]byte("hallo"))
+
+	fmt.Println(string(result))
+}
@@ -0,0 +1,177 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+type (
+	NodeHandler func(ctx context.Context, node *Node, param map[string][]byte) ([]byte, error)
+
+	Middleware func(next NodeHandler) NodeHandler
+
+	Hooks struct {
+		OnRunStart  func(ctx context.Context, event RunEvent)
+		OnNodeStart func(ctx context.Context, event NodeEvent)
+		OnNodeEnd   func(ctx context.Context, event NodeEvent)
+		OnRunEnd    func(ctx context.Context, event RunEvent)
+		OnError     func(ctx context.Context, event ErrorEvent)
+	}
+
+	RunEvent struct {
+		ID       string
+		Workflow string
+		Input    []byte
+		Result   []byte
+		Status   RunStatus
+		Err      error
+		Duration time.Duration
+	}
+
+	NodeEvent struct {
+		RunID    string
+		Workflow string
+		Node     string
+		Attempt  int
+		Param    map[string][]byte
+		Result   []byte
+		Err      error
+		Duration time.Duration
+	}
+
+	ErrorEvent struct {
+		RunID    string
+		Workflow string
+		Node     string
+		Err      error
+	}
+
+	chain struct {
+		lock       *sync.RWMutex
+		middleware []Middleware
+		hooks      []Hooks
+	}
+)
+
+var global = &chain{lock: &sync.RWMutex{}}
+
+func Use(middleware ...Middleware) {
+	global.lock.Lock()
+	global.middleware = append(global.middleware, middleware...)
+	global.lock.Unlock()
+}
+
+func UseHooks(hooks ...Hooks) {
+	global.lock.Lock()
+	global.hooks = append(global.hooks, hooks...)
+	global.lock.Unlock()
+}
+
+func WithMiddleware(middleware ...Middleware) WorkflowOption {
+	return func(w *Workflow) {
+		w.middleware = append(w.middleware, middleware...)
+	}
+}
+
+func WithHooks(hooks ...Hooks) WorkflowOption {
+	return func(w *Workflow) {
+		w.hooks = append(w.hooks, hooks...)
+	}
+}
+
+func (w *Workflow) handler() NodeHandler {
+	handler := NodeHandler(func(ctx context.Context, node *Node, param map[string][]byte) ([]byte, error) {
+		return node.action(ctx, param)
+	})
+
+	for i := len(w.middleware) - 1; i >= 0; i-- {
+		handler = w.middleware[i](handler)
+	}
+
+	global.lock.RLock()
+	defer global.lock.RUnlock()
+	for i := len(global.middleware) - 1; i >= 0; i-- {
+		handler = global.middleware[i](handler)
+	}
+
+	return handler
+}
+
+func (w *Workflow) allHooks() []Hooks {
+	global.lock.RLock()
+	hooks := make([]Hooks, 0, len(global.hooks)+len(w.hooks))
+	hooks = append(hooks, global.hooks...)
+	global.lock.RUnlock()
+
+	return append(hooks, w.hooks...)
+}
+
+func (w *Workflow) runStart(ctx context.Context, event RunEvent) {
+	for _, h := range w.allHooks() {
+		if h.OnRunStart != nil {
+			h.OnRunStart(ctx, event)
+		}
+	}
+}
+
+func (w *Workflow) runEnd(ctx context.Context, event RunEvent) {
+	for _, h := range w.allHooks() {
+		if h.OnRunEnd != nil {
+			h.OnRunEnd(ctx, event)
+		}
+	}
+
+	var nodeErr *NodeError
+	if event.Err != nil && !errors.As(event.Err, &nodeErr) {
+		w.fail(ctx, ErrorEvent{RunID: event.ID, Workflow: event.Workflow, Err: event.Err})
+	}
+}
+
+func (w *Workflow) nodeStart(ctx context.Context, event NodeEvent) {
+	for _, h := range w.allHooks() {
+		if h.OnNodeStart != nil {
+			h.OnNodeStart(ctx, event)
+		}
+	}
+}
+
+func (w *Workflow) nodeEnd(ctx context.Context, event NodeEvent, final bool) {
+	for _, h := range w.allHooks() {
+		if h.OnNodeEnd != nil {
+			h.OnNodeEnd(ctx, event)
+		}
+	}
+
+	if event.Err != nil && final {
+		w.fail(ctx, ErrorEvent{RunID: event.RunID, Workflow: event.Workflow, Node: event.Node, Err: event.Err})
+	}
+}
+
+func (w *Workflow) fail(ctx context.Context, event ErrorEvent) {
+	for _, h := range w.allHooks() {
+		if h.OnError != nil {
+			h.OnError(ctx, event)
+		}
+	}
+}
+
+func (w *Workflow) nodeEvent(ctx context.Context, step string, attempt int, param map[string][]byte) NodeEvent {
+	event := NodeEvent{Workflow: w.key, Node: step, Attempt: attempt, Param: param}
+	if state := runStateFrom(ctx); state != nil {
+		event.RunID = state.run.ID
+	}
+
+	return event
+}
+
+func (w *Workflow) fault(ctx context.Context, node *Node, err error) error {
+	event := ErrorEvent{Workflow: w.key, Node: stepKey(ctx, node.key), Err: err}
+	if state := runStateFrom(ctx); state != nil {
+		event.RunID = state.run.ID
+	}
+	w.fail(ctx, event)
+
+	return &NodeError{Node: node.key, Err: err}
+}
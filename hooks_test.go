@@ -0,0 +1,177 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func resetGlobalChain(t *testing.T) {
+	t.Helper()
+
+	t.Cleanup(func() {
+		global.lock.Lock()
+		global.middleware, global.hooks = nil, nil
+		global.lock.Unlock()
+	})
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	resetGlobalChain(t)
+
+	calls := []string{}
+	lock := &sync.Mutex{}
+	record := func(call string) {
+		lock.Lock()
+		calls = append(calls, call)
+		lock.Unlock()
+	}
+
+	middleware := func(name string) Middleware {
+		return func(next NodeHandler) NodeHandler {
+			return func(ctx context.Context, node *Node, param map[string][]byte) ([]byte, error) {
+				record(name + " before " + node.GetKey())
+				res, err := next(ctx, node, param)
+				record(name + " after " + node.GetKey())
+
+				return append(res, []byte("+"+name)...), err
+			}
+		}
+	}
+
+	Use(middleware("g1"), middleware("g2"))
+
+	start := NewNode("start", func(param map[string][]byte) ([]byte, error) {
+		record("start")
+
+		return param["data"], nil
+	})
+	next := NewNode("next", func(param map[string][]byte) ([]byte, error) {
+		return param["data"], nil
+	})
+
+	skip := Middleware(func(next NodeHandler) NodeHandler {
+		return func(ctx context.Context, node *Node, param map[string][]byte) ([]byte, error) {
+			if node.GetKey() == "next" {
+				return []byte("skipped"), nil
+			}
+
+			return next(ctx, node, param)
+		}
+	})
+
+	w := newTestWorkflow("middleware", WithMiddleware(middleware("w1"), middleware("w2"), skip))
+	w.AddNode(start, next)
+	if err := w.AddEdge(start, next); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := w.Execute([]byte("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(res) != "skipped+w2+w1+g2+g1" {
+		t.Fatalf("unexpected result %q", res)
+	}
+
+	want := []string{
+		"g1 before start", "g2 before start", "w1 before start", "w2 before start",
+		"start",
+		"w2 after start", "w1 after start", "g2 after start", "g1 after start",
+		"g1 before next", "g2 before next", "w1 before next", "w2 before next",
+		"w2 after next", "w1 after next", "g2 after next", "g1 after next",
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("expected %v, got %v", want, calls)
+	}
+}
+
+func TestHooksOrder(t *testing.T) {
+	resetGlobalChain(t)
+
+	events := []string{}
+	hooks := func(name string) Hooks {
+		return Hooks{
+			OnRunStart: func(ctx context.Context, event RunEvent) {
+				events = append(events, name+" run start "+event.Workflow)
+			},
+			OnNodeStart: func(ctx context.Context, event NodeEvent) {
+				events = append(events, name+" node start "+event.Node+" #"+strconv.Itoa(event.Attempt))
+			},
+			OnNodeEnd: func(ctx context.Context, event NodeEvent) {
+				events = append(events, name+" node end "+event.Node+" "+outcome(event.Err, false))
+			},
+			OnError: func(ctx context.Context, event ErrorEvent) {
+				events = append(events, name+" error "+event.Node)
+			},
+			OnRunEnd: func(ctx context.Context, event RunEvent) {
+				events = append(events, name+" run end "+string(event.Status))
+			},
+		}
+	}
+
+	UseHooks(hooks("global"))
+
+	calls := 0
+	flaky := NewNode("flaky", func(param map[string][]byte) ([]byte, error) {
+		calls++
+		if calls == 1 {
+			return nil, errors.New("temporary")
+		}
+
+		return param["data"], nil
+	}, WithRetry(RetryPolicy{MaxAttempts: 2}))
+	bad := failNode("bad")
+
+	w := newTestWorkflow("hooks", WithHooks(hooks("local")))
+	w.AddNode(flaky, bad)
+	if err := w.AddEdge(flaky, bad); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.Execute([]byte("x")); err == nil {
+		t.Fatal("expected run to fail")
+	}
+
+	want := []string{
+		"global run start hooks", "local run start hooks",
+		"global node start flaky #1", "local node start flaky #1",
+		"global node end flaky failed", "local node end flaky failed",
+		"global node start flaky #2", "local node start flaky #2",
+		"global node end flaky success", "local node end flaky success",
+		"global node start bad #1", "local node start bad #1",
+		"global node end bad failed", "local node end bad failed",
+		"global error bad", "local error bad",
+		"global run end failed", "local run end failed",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("expected %v, got %v", want, events)
+	}
+}
+
+func TestErrorHookForEngineErrors(t *testing.T) {
+	errs := []ErrorEvent{}
+	start, condition, yes, no := echoNode("start"), echoNode("condition"), echoNode("yes"), echoNode("no")
+	w := newTestWorkflow("strict", WithStrictConditions(), WithHooks(Hooks{
+		OnError: func(ctx context.Context, event ErrorEvent) {
+			errs = append(errs, event)
+		},
+	}))
+	w.AddNode(start, condition, yes, no)
+	if err := w.AddConditionalEdge(start, condition, yes, no); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.ExecuteRun(context.Background(), "run-1", []byte("x")); err == nil {
+		t.Fatal("expected run to fail")
+	}
+
+	conditionErr := &ConditionError{}
+	if len(errs) != 1 || errs[0].Node != "condition" || errs[0].RunID != "run-1" || !errors.As(errs[0].Err, &conditionErr) {
+		t.Fatalf("expected one condition error event, got %+v", errs)
+	}
+}
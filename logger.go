@@ -0,0 +1,165 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+type (
+	Logger interface {
+		Info(ctx context.Context, msg string, fields ...Field)
+		Warn(ctx context.Context, msg string, fields ...Field)
+		Error(ctx context.Context, msg string, fields ...Field)
+	}
+
+	Field struct {
+		Key   string
+		Value any
+	}
+
+	Redactor func(node string, payload []byte) []byte
+
+	slogLogger struct {
+		logger *slog.Logger
+	}
+
+	loggerKey struct{}
+)
+
+func NewSlogLogger(logger *slog.Logger) Logger {
+	return &slogLogger{logger: logger}
+}
+
+func (l *slogLogger) Info(ctx context.Context, msg string, fields ...Field) {
+	l.log(ctx, slog.LevelInfo, msg, fields)
+}
+
+func (l *slogLogger) Warn(ctx context.Context, msg string, fields ...Field) {
+	l.log(ctx, slog.LevelWarn, msg, fields)
+}
+
+func (l *slogLogger) Error(ctx context.Context, msg string, fields ...Field) {
+	l.log(ctx, slog.LevelError, msg, fields)
+}
+
+func (l *slogLogger) log(ctx context.Context, level slog.Level, msg string, fields []Field) {
+	logger := l.logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	attrs := make([]slog.Attr, 0, len(fields))
+	for _, f := range fields {
+		attrs = append(attrs, slog.Any(f.Key, f.Value))
+	}
+
+	logger.LogAttrs(ctx, level, msg, attrs...)
+}
+
+func WithLogger(logger Logger) WorkflowOption {
+	return func(w *Workflow) {
+		w.logger = logger
+	}
+}
+
+func WithPayloadLogging(redact Redactor) WorkflowOption {
+	return func(w *Workflow) {
+		w.logPayload = true
+		w.redact = redact
+	}
+}
+
+func WithServerLogger(logger Logger) ServerOption {
+	return func(s *server) {
+		s.logger = logger
+	}
+}
+
+func withLogger(ctx context.Context, logger Logger) context.Context {
+	if logger == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+func (w *Workflow) log(ctx context.Context) Logger {
+	if w.logger != nil {
+		return w.logger
+	}
+
+	if logger, ok := ctx.Value(loggerKey{}).(Logger); ok {
+		return logger
+	}
+
+	return NewSlogLogger(nil)
+}
+
+func (w *Workflow) fields(ctx context.Context, fields ...Field) []Field {
+	base := make([]Field, 0, len(fields)+2)
+	if state := runStateFrom(ctx); state != nil {
+		base = append(base, Field{Key: "run_id", Value: state.run.ID})
+	}
+
+	base = append(base, Field{Key: "workflow", Value: w.key})
+
+	return append(base, fields...)
+}
+
+func (w *Workflow) payload(node string, key string, data []byte) []Field {
+	if !w.logPayload {
+		return nil
+	}
+
+	if w.redact != nil {
+		data = w.redact(node, data)
+	}
+
+	return []Field{{Key: key, Value: string(data)}}
+}
+
+func (w *Workflow) logAttempt(ctx context.Context, step string, attempt int, duration time.Duration, param map[string][]byte, result []byte, err error, retry bool) {
+	fields := w.fields(ctx,
+		Field{Key: "node", Value: step},
+		Field{Key: "attempt", Value: attempt},
+		Field{Key: "duration", Value: duration},
+		Field{Key: "outcome", Value: outcome(err, retry)},
+	)
+	fields = append(fields, w.payload(step, "param", param["data"])...)
+	if err == nil {
+		w.log(ctx).Info(ctx, "node executed", append(fields, w.payload(step, "result", result)...)...)
+
+		return
+	}
+
+	fields = append(fields, Field{Key: "error", Value: err.Error()})
+	if retry {
+		w.log(ctx).Warn(ctx, "node attempt failed", fields...)
+
+		return
+	}
+
+	w.log(ctx).Error(ctx, "node failed", fields...)
+}
+
+func outcome(err error, retry bool) string {
+	var (
+		canceled *CanceledError
+		timeout  *TimeoutError
+	)
+
+	switch {
+	case err == nil:
+		return "success"
+	case retry:
+		return "retry"
+	case errors.As(err, &canceled):
+		return "canceled"
+	case errors.As(err, &timeout):
+		return "timeout"
+	}
+
+	return "failed"
+}
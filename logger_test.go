@@ -0,0 +1,177 @@
+package flow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type (
+	entry struct {
+		level  string
+		msg    string
+		fields map[string]any
+	}
+
+	recordingLogger struct {
+		lock    *sync.Mutex
+		entries []entry
+	}
+)
+
+func newRecordingLogger() *recordingLogger {
+	return &recordingLogger{lock: &sync.Mutex{}}
+}
+
+func (l *recordingLogger) Info(ctx context.Context, msg string, fields ...Field) {
+	l.record("info", msg, fields)
+}
+
+func (l *recordingLogger) Warn(ctx context.Context, msg string, fields ...Field) {
+	l.record("warn", msg, fields)
+}
+
+func (l *recordingLogger) Error(ctx context.Context, msg string, fields ...Field) {
+	l.record("error", msg, fields)
+}
+
+func (l *recordingLogger) record(level string, msg string, fields []Field) {
+	values := make(map[string]any, len(fields))
+	for _, f := range fields {
+		values[f.Key] = f.Value
+	}
+
+	l.lock.Lock()
+	l.entries = append(l.entries, entry{level: level, msg: msg, fields: values})
+	l.lock.Unlock()
+}
+
+func (l *recordingLogger) node(key string) entry {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for _, e := range l.entries {
+		if e.fields["node"] == key {
+			return e
+		}
+	}
+
+	return entry{}
+}
+
+func TestPayloadLogging(t *testing.T) {
+	redact := func(node string, payload []byte) []byte {
+		if node == "secret" {
+			return []byte("***")
+		}
+
+		return payload
+	}
+
+	cases := []struct {
+		name    string
+		options []WorkflowOption
+		want    map[string]any
+	}{
+		{name: "off by default", want: map[string]any{}},
+		{name: "enabled", options: []WorkflowOption{WithPayloadLogging(nil)}, want: map[string]any{"param": "xstart", "result": "xstartsecret"}},
+		{name: "redacted", options: []WorkflowOption{WithPayloadLogging(redact)}, want: map[string]any{"param": "***", "result": "***"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			logger := newRecordingLogger()
+			start, secret := echoNode("start"), echoNode("secret")
+			w := newTestWorkflow("logged", append([]WorkflowOption{WithLogger(logger)}, c.options...)...)
+			w.AddNode(start, secret)
+			if err := w.AddEdge(start, secret); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := w.ExecuteRun(context.Background(), "run-1", []byte("x")); err != nil {
+				t.Fatal(err)
+			}
+
+			e := logger.node("secret")
+			if e.msg != "node executed" || e.level != "info" {
+				t.Fatalf("unexpected entry %+v", e)
+			}
+
+			for _, key := range []string{"run_id", "workflow", "attempt", "duration", "outcome"} {
+				if _, ok := e.fields[key]; !ok {
+					t.Fatalf("missing field %s in %v", key, e.fields)
+				}
+			}
+
+			payload := map[string]any{}
+			for _, key := range []string{"param", "result"} {
+				if v, ok := e.fields[key]; ok {
+					payload[key] = v
+				}
+			}
+
+			if !reflect.DeepEqual(payload, c.want) {
+				t.Fatalf("expected payload %v, got %v", c.want, payload)
+			}
+		})
+	}
+}
+
+func TestLoggerLevels(t *testing.T) {
+	logger := newRecordingLogger()
+	calls := 0
+	flaky := NewNode("flaky", func(param map[string][]byte) ([]byte, error) {
+		calls++
+		if calls == 1 {
+			return nil, errors.New("temporary")
+		}
+
+		return param["data"], nil
+	}, WithRetry(RetryPolicy{MaxAttempts: 2}))
+
+	start, bad := echoNode("start"), failNode("bad")
+	w := newTestWorkflow("levels", WithLogger(logger))
+	w.AddNode(start, flaky, bad)
+	if err := w.AddEdge(start, flaky); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.AddEdge(flaky, bad); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.Execute([]byte("x")); err == nil {
+		t.Fatal("expected run to fail")
+	}
+
+	got := make([]string, 0, len(logger.entries))
+	for _, e := range logger.entries {
+		got = append(got, e.level+" "+e.msg)
+	}
+
+	want := []string{"info node executed", "warn node attempt failed", "info node executed", "error node failed", "error run failed"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestSlogLogger(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	logger := NewSlogLogger(slog.New(slog.NewJSONHandler(buffer, nil)))
+	logger.Warn(context.Background(), "node attempt failed", Field{Key: "node", Value: "a"}, Field{Key: "attempt", Value: 2})
+
+	record := map[string]any{}
+	if err := json.Unmarshal(buffer.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+
+	if record["level"] != "WARN" || record["msg"] != "node attempt failed" || record["node"] != "a" || record["attempt"] != float64(2) {
+		t.Fatalf("unexpected record %s", strings.TrimSpace(buffer.String()))
+	}
+}
@@ -0,0 +1,130 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type (
+	Metrics struct {
+		registry     *prometheus.Registry
+		runs         *prometheus.CounterVec
+		inFlight     *prometheus.GaugeVec
+		nodeDuration *prometheus.HistogramVec
+		branches     *prometheus.GaugeVec
+		retries      *prometheus.CounterVec
+		timeouts     *prometheus.CounterVec
+	}
+
+	metricsKey struct{}
+)
+
+var defaultMetrics = NewMetrics(nil)
+
+func NewMetrics(registry *prometheus.Registry) *Metrics {
+	if registry == nil {
+		registry = prometheus.NewRegistry()
+	}
+
+	m := &Metrics{
+		registry: registry,
+		runs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "flow_runs_total",
+			Help: "Number of finished workflow runs by outcome.",
+		}, []string{"workflow", "outcome"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "flow_runs_in_flight",
+			Help: "Number of workflow runs currently executing.",
+		}, []string{"workflow"}),
+		nodeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "flow_node_duration_seconds",
+			Help:    "Latency of node attempts by outcome.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"workflow", "node", "outcome"}),
+		branches: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "flow_parallel_branches_active",
+			Help: "Number of parallel or map branches currently executing.",
+		}, []string{"workflow", "node"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "flow_node_retries_total",
+			Help: "Number of node attempts that failed and were retried.",
+		}, []string{"workflow", "node"}),
+		timeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "flow_node_timeouts_total",
+			Help: "Number of node attempts that exceeded their timeout.",
+		}, []string{"workflow", "node"}),
+	}
+
+	registry.MustRegister(m.runs, m.inFlight, m.nodeDuration, m.branches, m.retries, m.timeouts)
+
+	return m
+}
+
+func DefaultMetrics() *Metrics {
+	return defaultMetrics
+}
+
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func WithMetrics(metrics *Metrics) WorkflowOption {
+	return func(w *Workflow) {
+		w.metrics = metrics
+	}
+}
+
+func WithServerMetrics(metrics *Metrics) ServerOption {
+	return func(s *server) {
+		s.metrics = metrics
+	}
+}
+
+func withMetrics(ctx context.Context, metrics *Metrics) context.Context {
+	if metrics == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, metricsKey{}, metrics)
+}
+
+func (w *Workflow) metric(ctx context.Context) *Metrics {
+	if w.metrics != nil {
+		return w.metrics
+	}
+
+	if metrics, ok := ctx.Value(metricsKey{}).(*Metrics); ok {
+		return metrics
+	}
+
+	return defaultMetrics
+}
+
+func (w *Workflow) observeAttempt(ctx context.Context, node *Node, duration time.Duration, err error, retry bool) {
+	m := w.metric(ctx)
+	m.nodeDuration.WithLabelValues(w.key, node.key, outcome(err, retry)).Observe(duration.Seconds())
+	if retry {
+		m.retries.WithLabelValues(w.key, node.key).Inc()
+	}
+
+	var timeout *TimeoutError
+	if errors.As(err, &timeout) {
+		m.timeouts.WithLabelValues(w.key, node.key).Inc()
+	}
+}
+
+func (w *Workflow) branchStarted(ctx context.Context, node *Node) func() {
+	gauge := w.metric(ctx).branches.WithLabelValues(w.key, node.key)
+	gauge.Inc()
+
+	return gauge.Dec
+}
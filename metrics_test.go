@@ -0,0 +1,79 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	calls := 0
+	flaky := NewNode("flaky", func(param map[string][]byte) ([]byte, error) {
+		calls++
+		if calls == 1 {
+			return nil, errors.New("temporary")
+		}
+
+		return param["data"], nil
+	}, WithRetry(RetryPolicy{MaxAttempts: 2}))
+
+	metered := NewWorkflow("metered", WithLogger(discard))
+	start, b1, b2, aggregate := echoNode("start"), echoNode("b1"), echoNode("b2"), echoNode("aggregate")
+	metered.AddNode(start, flaky, b1, b2, aggregate)
+	if err := errors.Join(metered.AddEdge(start, flaky), metered.AddParallelEdge(flaky, aggregate, b1, b2)); err != nil {
+		t.Fatal(err)
+	}
+
+	slow := NewWorkflow("slow", WithLogger(discard))
+	begin, blocked := echoNode("start"), NewNodeWithContext("blocked", func(ctx context.Context, param map[string][]byte) ([]byte, error) {
+		<-ctx.Done()
+
+		return nil, ctx.Err()
+	}, WithNodeTimeout(10*time.Millisecond))
+	slow.AddNode(begin, blocked)
+	if err := slow.AddEdge(begin, blocked); err != nil {
+		t.Fatal(err)
+	}
+
+	storage := NewInMemoryStorage()
+	for _, w := range []*Workflow{metered, slow} {
+		if err := storage.Save(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e := NewServer(storage, WithServerLogger(discard), WithServerMetrics(NewMetrics(nil))).GetEcho()
+	if rec := request(e, http.MethodPost, "/execute/metered", `{"param":"x"}`); rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := request(e, http.MethodPost, "/execute/slow", `{"param":"x"}`); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec := request(e, http.MethodGet, "/metrics", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", rec.Code)
+	}
+
+	want := []string{
+		`flow_runs_total{outcome="completed",workflow="metered"} 1`,
+		`flow_runs_total{outcome="failed",workflow="slow"} 1`,
+		`flow_runs_in_flight{workflow="metered"} 0`,
+		`flow_node_duration_seconds_count{node="start",outcome="success",workflow="metered"} 1`,
+		`flow_node_duration_seconds_count{node="flaky",outcome="retry",workflow="metered"} 1`,
+		`flow_node_duration_seconds_count{node="flaky",outcome="success",workflow="metered"} 1`,
+		`flow_node_duration_seconds_count{node="blocked",outcome="timeout",workflow="slow"} 1`,
+		`flow_node_retries_total{node="flaky",workflow="metered"} 1`,
+		`flow_node_timeouts_total{node="blocked",workflow="slow"} 1`,
+		`flow_parallel_branches_active{node="flaky",workflow="metered"} 0`,
+	}
+	for _, metric := range want {
+		if !strings.Contains(rec.Body.String(), metric) {
+			t.Fatalf("expected metrics to contain %s:\n%s", metric, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	Multiplier  float64
+	Jitter      float64
+	Retryable   func(err error) bool
+}
+
+func WithRetry(policy RetryPolicy) NodeOption {
+	return func(n *Node) {
+		n.retry = policy
+	}
+}
+
+func (p RetryPolicy) attempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+
+	return p.MaxAttempts
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	var canceled *CanceledError
+	if errors.As(err, &canceled) {
+		return false
+	}
+
+	if p.Retryable == nil {
+		return true
+	}
+
+	return p.Retryable(err)
+}
+
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+
+	delay := float64(p.Backoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && delay > float64(p.MaxBackoff) {
+		delay = float64(p.MaxBackoff)
+	}
+
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (rand.Float64()*2 - 1)
+	}
+
+	if delay < 0 {
+		return 0
+	}
+
+	return time.Duration(delay)
+}
+
+func (p RetryPolicy) wait(ctx context.Context, attempt int) error {
+	timer := time.NewTimer(p.delay(attempt))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
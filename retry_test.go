@@ -0,0 +1,94 @@
+package flow
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	errPermanent := errors.New("permanent")
+	cases := []struct {
+		name      string
+		policy    RetryPolicy
+		failures  int
+		err       error
+		wantCalls int32
+		wantErr   bool
+	}{
+		{name: "no retry", policy: RetryPolicy{}, failures: 1, wantCalls: 1, wantErr: true},
+		{name: "succeeds on last attempt", policy: RetryPolicy{MaxAttempts: 3}, failures: 2, wantCalls: 3},
+		{name: "exhausted", policy: RetryPolicy{MaxAttempts: 2}, failures: 5, wantCalls: 2, wantErr: true},
+		{
+			name:      "not retryable",
+			policy:    RetryPolicy{MaxAttempts: 3, Retryable: func(err error) bool { return !errors.Is(err, errPermanent) }},
+			failures:  5,
+			err:       errPermanent,
+			wantCalls: 1,
+			wantErr:   true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			calls := int32(0)
+			flaky := NewNode("flaky", func(param map[string][]byte) ([]byte, error) {
+				if atomic.AddInt32(&calls, 1) <= int32(c.failures) {
+					if c.err != nil {
+						return nil, c.err
+					}
+
+					return nil, errors.New("temporary")
+				}
+
+				return param["data"], nil
+			}, WithRetry(c.policy))
+
+			start := echoNode("start")
+			w := newTestWorkflow("retry")
+			w.AddNode(start, flaky)
+			if err := w.AddEdge(start, flaky); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := w.Execute([]byte("x"))
+			if (err != nil) != c.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if calls != c.wantCalls {
+				t.Fatalf("expected %d calls, got %d", c.wantCalls, calls)
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	cases := []struct {
+		name    string
+		policy  RetryPolicy
+		attempt int
+		want    time.Duration
+	}{
+		{name: "first attempt", policy: RetryPolicy{Backoff: 10 * time.Millisecond}, attempt: 1, want: 10 * time.Millisecond},
+		{name: "default multiplier", policy: RetryPolicy{Backoff: 10 * time.Millisecond}, attempt: 3, want: 40 * time.Millisecond},
+		{name: "custom multiplier", policy: RetryPolicy{Backoff: 10 * time.Millisecond, Multiplier: 3}, attempt: 3, want: 90 * time.Millisecond},
+		{name: "capped", policy: RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 25 * time.Millisecond}, attempt: 4, want: 25 * time.Millisecond},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.policy.delay(c.attempt); got != c.want {
+				t.Fatalf("expected %s, got %s", c.want, got)
+			}
+		})
+	}
+
+	jittered := RetryPolicy{Backoff: 100 * time.Millisecond, Jitter: 0.5}
+	for i := 0; i < 20; i++ {
+		if got := jittered.delay(1); got < 50*time.Millisecond || got > 150*time.Millisecond {
+			t.Fatalf("jittered delay %s out of range", got)
+		}
+	}
+}
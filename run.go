@@ -0,0 +1,529 @@
+package flow
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type (
+	RunStatus string
+
+	RunStore interface {
+		SaveRun(run *Run) error
+		GetRun(id string) (*Run, error)
+	}
+
+	Run struct {
+		ID       string            `json:"id"`
+		Workflow string            `json:"workflow"`
+		Status   RunStatus         `json:"status"`
+		Input    []byte            `json:"input"`
+		Current  string            `json:"current"`
+		Outputs  map[string][]byte `json:"outputs"`
+		Result   []byte            `json:"result,omitempty"`
+		Error    string            `json:"error,omitempty"`
+
+		Compensations []Compensation `json:"compensations,omitempty"`
+	}
+
+	Compensation struct {
+		Node  string `json:"node"`
+		Error string `json:"error,omitempty"`
+	}
+
+	CompensationError struct {
+		Err           error
+		Compensations []Compensation
+	}
+
+	completedStep struct {
+		node   *Node
+		step   string
+		param  map[string][]byte
+		result []byte
+	}
+
+	fileRunStore struct {
+		dir  string
+		lock *sync.Mutex
+	}
+
+	runState struct {
+		lock      *sync.Mutex
+		run       *Run
+		store     RunStore
+		completed []completedStep
+	}
+
+	runRegistry struct {
+		lock           *sync.Mutex
+		storage        Storage
+		runs           map[string]*asyncRun
+		retention      time.Duration
+		logger         Logger
+		metrics        *Metrics
+		tracerProvider trace.TracerProvider
+	}
+
+	asyncRun struct {
+		state  *runState
+		cancel context.CancelFunc
+	}
+
+	runStateKey struct{}
+
+	scopeKey struct{}
+)
+
+const (
+	RunRunning   RunStatus = "running"
+	RunCompleted RunStatus = "completed"
+	RunFailed    RunStatus = "failed"
+	RunCanceled  RunStatus = "canceled"
+)
+
+const DefaultRunRetention = 15 * time.Minute
+
+func (e *CompensationError) Error() string {
+	failed := 0
+	for _, c := range e.Compensations {
+		if c.Error != "" {
+			failed++
+		}
+	}
+
+	return fmt.Sprintf("%s (compensated %d nodes, %d failed)", e.Err.Error(), len(e.Compensations), failed)
+}
+
+func (e *CompensationError) Unwrap() error {
+	return e.Err
+}
+
+func NewFileRunStore(dir string) (*fileRunStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &fileRunStore{
+		dir:  dir,
+		lock: &sync.Mutex{},
+	}, nil
+}
+
+func (s *fileRunStore) SaveRun(run *Run) error {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	path, err := s.path(run.ID)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+func (s *fileRunStore) GetRun(id string) (*Run, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, fmt.Errorf("run '%s' not found", id)
+	}
+
+	s.lock.Lock()
+	data, err := os.ReadFile(path)
+	s.lock.Unlock()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("run '%s' not found", id)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	run := Run{}
+	if err := json.Unmarshal(data, &run); err != nil {
+		return nil, err
+	}
+
+	return &run, nil
+}
+
+func (s *fileRunStore) path(id string) (string, error) {
+	if id == "" || id == "." || id == ".." || filepath.Base(id) != id || strings.ContainsAny(id, `/\`) {
+		return "", fmt.Errorf("invalid run id '%s'", id)
+	}
+
+	return filepath.Join(s.dir, fmt.Sprintf("%s.json", id)), nil
+}
+
+func WithRunStore(store RunStore) WorkflowOption {
+	return func(w *Workflow) {
+		w.runStore = store
+	}
+}
+
+func (w *Workflow) ExecuteRun(ctx context.Context, runID string, param []byte) ([]byte, error) {
+	if w.runStore != nil {
+		if _, err := w.runStore.GetRun(runID); err == nil {
+			return nil, fmt.Errorf("run '%s' already exists, use Resume() to continue it", runID)
+		}
+	}
+
+	return w.executeRun(ctx, w.newRunState(runID, param))
+}
+
+func (w *Workflow) Resume(ctx context.Context, runID string) ([]byte, error) {
+	if w.runStore == nil {
+		return nil, errors.New("workflow has no run store, use WithRunStore() to enable checkpointing")
+	}
+
+	run, err := w.runStore.GetRun(runID)
+	if err != nil {
+		return nil, err
+	}
+
+	if run.Workflow != w.key {
+		return nil, fmt.Errorf("run '%s' belongs to workflow '%s'", runID, run.Workflow)
+	}
+
+	if run.Status == RunCompleted {
+		return run.Result, nil
+	}
+
+	if run.Outputs == nil {
+		run.Outputs = make(map[string][]byte)
+	}
+
+	return w.executeRun(ctx, &runState{
+		lock:  &sync.Mutex{},
+		run:   run,
+		store: w.runStore,
+	})
+}
+
+func (w *Workflow) newRunState(runID string, param []byte) *runState {
+	return &runState{
+		lock: &sync.Mutex{},
+		run: &Run{
+			ID:       runID,
+			Workflow: w.key,
+			Status:   RunRunning,
+			Input:    param,
+			Outputs:  make(map[string][]byte),
+		},
+		store: w.runStore,
+	}
+}
+
+func (w *Workflow) executeRun(ctx context.Context, state *runState) ([]byte, error) {
+	if w.root == nil {
+		return nil, errors.New("workflow has no edges, use AddEdge() to define the flow")
+	}
+
+	if w.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, w.timeout, errRunTimeout)
+		defer cancel()
+	}
+
+	state.lock.Lock()
+	state.run.Status = RunRunning
+	state.run.Error = ""
+	input := state.run.Input
+	err := state.saveLocked()
+	state.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	stack, _ := ctx.Value(callStackKey{}).([]string)
+	ctx = context.WithValue(ctx, callStackKey{}, append(stack[:len(stack):len(stack)], w.key))
+	ctx = context.WithValue(ctx, runStateKey{}, state)
+	ctx = withLogger(ctx, w.logger)
+	ctx = withMetrics(ctx, w.metrics)
+	ctx = withTracerProvider(ctx, w.tracerProvider)
+
+	ctx, span := w.startRunSpan(ctx, state.run.ID)
+	defer span.End()
+
+	inFlight := w.metric(ctx).inFlight.WithLabelValues(w.key)
+	inFlight.Inc()
+	defer inFlight.Dec()
+
+	started := time.Now()
+	w.runStart(ctx, RunEvent{ID: state.run.ID, Workflow: w.key, Input: input, Status: RunRunning})
+	res, err := w.protectedDispatch(ctx, w.root, input)
+	if err != nil {
+		if compensations := w.compensate(context.WithoutCancel(ctx), state); len(compensations) > 0 {
+			err = &CompensationError{Err: err, Compensations: compensations}
+		}
+	}
+	failSpan(span, err)
+
+	if err := state.finish(res, err); err != nil {
+		return nil, err
+	}
+
+	duration, status := time.Since(started), state.snapshot().Status
+	w.metric(ctx).runs.WithLabelValues(w.key, string(status)).Inc()
+	w.runEnd(ctx, RunEvent{ID: state.run.ID, Workflow: w.key, Input: input, Result: res, Status: status, Err: err, Duration: duration})
+
+	fields := w.fields(ctx, Field{Key: "duration", Value: duration}, Field{Key: "outcome", Value: status})
+	if err != nil {
+		w.log(ctx).Error(ctx, "run failed", append(fields, Field{Key: "error", Value: err.Error()})...)
+
+		return res, err
+	}
+
+	w.log(ctx).Info(ctx, "run finished", fields...)
+
+	return res, err
+}
+
+func newRunID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+func runStateFrom(ctx context.Context) *runState {
+	state, _ := ctx.Value(runStateKey{}).(*runState)
+
+	return state
+}
+
+func withScope(ctx context.Context, scope string) context.Context {
+	return context.WithValue(ctx, scopeKey{}, stepKey(ctx, scope))
+}
+
+func stepKey(ctx context.Context, key string) string {
+	scope, _ := ctx.Value(scopeKey{}).(string)
+
+	return scope + key
+}
+
+func (s *runState) checkpoint(step string) ([]byte, bool) {
+	if s == nil {
+		return nil, false
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	out, ok := s.run.Outputs[step]
+
+	return out, ok
+}
+
+func (s *runState) start(step string) error {
+	if s == nil {
+		return nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.run.Current = step
+
+	return s.saveLocked()
+}
+
+func (s *runState) complete(step string, result []byte) error {
+	if s == nil {
+		return nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.run.Outputs[step] = result
+
+	return s.saveLocked()
+}
+
+func (s *runState) track(node *Node, step string, param map[string][]byte, result []byte) {
+	if s == nil || node.compensate == nil {
+		return
+	}
+
+	s.lock.Lock()
+	s.completed = append(s.completed, completedStep{node: node, step: step, param: param, result: result})
+	s.lock.Unlock()
+}
+
+func (w *Workflow) compensate(ctx context.Context, s *runState) []Compensation {
+	s.lock.Lock()
+	completed := s.completed
+	s.completed = nil
+	s.lock.Unlock()
+
+	compensations := make([]Compensation, 0, len(completed))
+	for i := len(completed) - 1; i >= 0; i-- {
+		c := completed[i]
+		compensation := Compensation{Node: c.step}
+		started := time.Now()
+		_, err := invoke(ctx, func(ctx context.Context, node *Node, param map[string][]byte) ([]byte, error) {
+			return node.compensate(ctx, param)
+		}, c.node, map[string][]byte{"data": c.result, "input": c.param["data"]})
+		fields := w.fields(ctx, Field{Key: "node", Value: c.step}, Field{Key: "duration", Value: time.Since(started)}, Field{Key: "outcome", Value: outcome(err, false)})
+		if err != nil {
+			compensation.Error = err.Error()
+			w.log(ctx).Error(ctx, "node compensation failed", append(fields, Field{Key: "error", Value: err.Error()})...)
+		} else {
+			w.log(ctx).Info(ctx, "node compensated", fields...)
+		}
+
+		s.lock.Lock()
+		if compensation.Error == "" {
+			delete(s.run.Outputs, c.step)
+		}
+		s.lock.Unlock()
+
+		compensations = append(compensations, compensation)
+	}
+
+	s.lock.Lock()
+	s.run.Compensations = append(s.run.Compensations, compensations...)
+	s.lock.Unlock()
+
+	return compensations
+}
+
+func (s *runState) finish(result []byte, err error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.run.Status = runStatus(err)
+	s.run.Result = result
+	if err != nil {
+		s.run.Error = err.Error()
+	}
+
+	return s.saveLocked()
+}
+
+func runStatus(err error) RunStatus {
+	var canceled *CanceledError
+	switch {
+	case err == nil:
+		return RunCompleted
+	case errors.As(err, &canceled):
+		return RunCanceled
+	}
+
+	return RunFailed
+}
+
+func (s *runState) snapshot() Run {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	run := *s.run
+	run.Outputs = make(map[string][]byte, len(s.run.Outputs))
+	for k, v := range s.run.Outputs {
+		run.Outputs[k] = v
+	}
+
+	return run
+}
+
+func (s *runState) saveLocked() error {
+	if s.store == nil {
+		return nil
+	}
+
+	return s.store.SaveRun(s.run)
+}
+
+func newRunRegistry(storage Storage) *runRegistry {
+	return &runRegistry{
+		lock:      &sync.Mutex{},
+		storage:   storage,
+		runs:      make(map[string]*asyncRun),
+		retention: DefaultRunRetention,
+	}
+}
+
+func WithRunRetention(retention time.Duration) ServerOption {
+	return func(s *server) {
+		s.runs.retention = retention
+	}
+}
+
+func (r *runRegistry) context(ctx context.Context) context.Context {
+	return withTracerProvider(withMetrics(withLogger(ctx, r.logger), r.metrics), r.tracerProvider)
+}
+
+func (r *runRegistry) start(ctx context.Context, name string, param []byte) (string, error) {
+	w, err := r.storage.Get(name)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithCancel(r.context(context.WithoutCancel(ctx)))
+	run := &asyncRun{
+		state:  w.newRunState(newRunID(), param),
+		cancel: cancel,
+	}
+
+	r.lock.Lock()
+	r.runs[run.state.run.ID] = run
+	r.lock.Unlock()
+
+	go func() {
+		defer cancel()
+
+		w.executeRun(ctx, run.state)
+		time.AfterFunc(r.retention, func() {
+			r.lock.Lock()
+			delete(r.runs, run.state.run.ID)
+			r.lock.Unlock()
+		})
+	}()
+
+	return run.state.run.ID, nil
+}
+
+func (r *runRegistry) get(id string) (Run, error) {
+	r.lock.Lock()
+	run, ok := r.runs[id]
+	r.lock.Unlock()
+	if !ok {
+		return Run{}, fmt.Errorf("run '%s' not found", id)
+	}
+
+	return run.state.snapshot(), nil
+}
+
+func (r *runRegistry) cancel(id string) (Run, error) {
+	r.lock.Lock()
+	run, ok := r.runs[id]
+	r.lock.Unlock()
+	if !ok {
+		return Run{}, fmt.Errorf("run '%s' not found", id)
+	}
+
+	run.cancel()
+
+	return run.state.snapshot(), nil
+}
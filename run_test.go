@@ -0,0 +1,316 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestResumeSkipsCompletedNodes(t *testing.T) {
+	store, err := NewFileRunStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := map[string]*int32{"first": new(int32), "second": new(int32), "third": new(int32)}
+	available := atomic.Bool{}
+	counted := func(key string) *Node {
+		return NewNode(key, func(param map[string][]byte) ([]byte, error) {
+			atomic.AddInt32(calls[key], 1)
+			if key == "second" && !available.Load() {
+				return nil, errors.New("unavailable")
+			}
+
+			return []byte(string(param["data"]) + key), nil
+		})
+	}
+
+	first, second, third := counted("first"), counted("second"), counted("third")
+	w := newTestWorkflow("resume", WithRunStore(store))
+	w.AddNode(first, second, third)
+	if err := w.AddEdge(first, second); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.AddEdge(second, third); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.ExecuteRun(context.Background(), "run-1", []byte("x")); err == nil {
+		t.Fatal("expected first run to fail")
+	}
+
+	run, err := store.GetRun("run-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if run.Status != RunFailed || string(run.Outputs["first"]) != "xfirst" {
+		t.Fatalf("unexpected run after failure: %+v", run)
+	}
+
+	available.Store(true)
+	res, err := w.Resume(context.Background(), "run-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(res) != "xfirstsecondthird" {
+		t.Fatalf("unexpected result %s", res)
+	}
+
+	want := map[string]int32{"first": 1, "second": 2, "third": 1}
+	for key, n := range want {
+		if got := atomic.LoadInt32(calls[key]); got != n {
+			t.Fatalf("expected %s to run %d times, got %d", key, n, got)
+		}
+	}
+
+}
+
+func TestExecuteRunID(t *testing.T) {
+	store, err := NewFileRunStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start, next := echoNode("start"), echoNode("next")
+	w := newTestWorkflow("run-id", WithRunStore(store))
+	w.AddNode(start, next)
+	if err := w.AddEdge(start, next); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.ExecuteRun(context.Background(), "run-1", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		id      string
+		wantErr string
+	}{
+		{name: "existing run", id: "run-1", wantErr: "use Resume()"},
+		{name: "parent directory", id: "../escape", wantErr: "invalid run id"},
+		{name: "nested path", id: "a/b", wantErr: "invalid run id"},
+		{name: "empty", id: "", wantErr: "invalid run id"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := w.ExecuteRun(context.Background(), c.id, []byte("y"))
+			if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", c.wantErr, err)
+			}
+		})
+	}
+
+	run, err := store.GetRun("run-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if run.Status != RunCompleted || string(run.Input) != "x" || string(run.Result) != "xstartnext" {
+		t.Fatalf("existing run was overwritten: %+v", run)
+	}
+}
+
+func request(e *echo.Echo, method string, path string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func startRun(t *testing.T, e *echo.Echo, workflow string) string {
+	t.Helper()
+
+	rec := request(e, http.MethodPost, "/execute/"+workflow, `{"param":"x","async":true}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	out := map[string]string{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	return out["id"]
+}
+
+func waitRun(t *testing.T, e *echo.Echo, id string, done func(code int, run RunResponse) bool) RunResponse {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rec := request(e, http.MethodGet, "/runs/"+id, "")
+		run := RunResponse{}
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &run); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if done(rec.Code, run) {
+			return run
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("run %s did not settle, last status %d: %s", id, rec.Code, rec.Body.String())
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestAsyncRuns(t *testing.T) {
+	storage := NewInMemoryStorage()
+
+	quick := newTestWorkflow("quick")
+	start, next := echoNode("start"), echoNode("next")
+	quick.AddNode(start, next)
+	if err := quick.AddEdge(start, next); err != nil {
+		t.Fatal(err)
+	}
+
+	slow := newTestWorkflow("slow")
+	blocked := NewNodeWithContext("blocked", func(ctx context.Context, param map[string][]byte) ([]byte, error) {
+		<-ctx.Done()
+
+		return nil, ctx.Err()
+	})
+	begin := echoNode("start")
+	slow.AddNode(begin, blocked)
+	if err := slow.AddEdge(begin, blocked); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, w := range []*Workflow{quick, slow} {
+		if err := storage.Save(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e := NewServer(storage, WithServerLogger(discard), WithServerMetrics(NewMetrics(nil)), WithRunRetention(50*time.Millisecond)).GetEcho()
+
+	t.Run("completed", func(t *testing.T) {
+		id := startRun(t, e, "quick")
+		run := waitRun(t, e, id, func(code int, run RunResponse) bool {
+			return run.Status != RunRunning
+		})
+
+		if run.Status != RunCompleted || run.Result != "xstartnext" || run.Outputs["start"] != "xstart" {
+			t.Fatalf("unexpected run %+v", run)
+		}
+
+		waitRun(t, e, id, func(code int, run RunResponse) bool {
+			return code == http.StatusNotFound
+		})
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		id := startRun(t, e, "slow")
+		waitRun(t, e, id, func(code int, run RunResponse) bool {
+			return run.Current == "blocked"
+		})
+
+		rec := request(e, http.MethodDelete, "/runs/"+id, "")
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+		}
+
+		run := waitRun(t, e, id, func(code int, run RunResponse) bool {
+			return run.Status != RunRunning
+		})
+
+		if run.Status != RunCanceled || !strings.Contains(run.Error, "blocked") {
+			t.Fatalf("unexpected run %+v", run)
+		}
+	})
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{name: "get unknown run", method: http.MethodGet, path: "/runs/missing"},
+		{name: "cancel unknown run", method: http.MethodDelete, path: "/runs/missing"},
+		{name: "start unknown workflow", method: http.MethodPost, path: "/execute/missing", body: `{"param":"x","async":true}`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if rec := request(e, c.method, c.path, c.body); rec.Code != http.StatusNotFound {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusNotFound, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestCompensation(t *testing.T) {
+	store, err := NewFileRunStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compensated := []string{}
+	compensable := func(key string, failing bool) *Node {
+		return NewNode(key, func(param map[string][]byte) ([]byte, error) {
+			return []byte(string(param["data"]) + key), nil
+		}, WithCompensation(func(ctx context.Context, param map[string][]byte) ([]byte, error) {
+			compensated = append(compensated, key+" "+string(param["input"])+" "+string(param["data"]))
+			if failing {
+				return nil, errors.New("undo failed")
+			}
+
+			return nil, nil
+		}))
+	}
+
+	first, second, plain, bad := compensable("first", false), compensable("second", true), echoNode("plain"), failNode("bad")
+	w := newTestWorkflow("saga", WithRunStore(store))
+	w.AddNode(first, second, plain, bad)
+	if err := errors.Join(w.AddEdge(first, second), w.AddEdge(second, plain), w.AddEdge(plain, bad)); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = w.ExecuteRun(context.Background(), "run-1", []byte("x"))
+	compensation := &CompensationError{}
+	if !errors.As(err, &compensation) {
+		t.Fatalf("expected compensation error, got %v", err)
+	}
+
+	want := []Compensation{{Node: "second", Error: "undo failed"}, {Node: "first"}}
+	if !reflect.DeepEqual(compensation.Compensations, want) {
+		t.Fatalf("expected %v, got %v", want, compensation.Compensations)
+	}
+
+	if !reflect.DeepEqual(compensated, []string{"second xfirst xfirstsecond", "first x xfirst"}) {
+		t.Fatalf("unexpected compensation calls %v", compensated)
+	}
+
+	run, err := store.GetRun("run-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := run.Outputs["first"]; ok || run.Status != RunFailed || !reflect.DeepEqual(run.Compensations, want) {
+		t.Fatalf("unexpected run after compensation: %+v", run)
+	}
+
+	if _, ok := run.Outputs["second"]; !ok {
+		t.Fatal("expected output of a failed compensation to be kept")
+	}
+}
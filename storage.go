@@ -0,0 +1,240 @@
+package flow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	errInvalidName   = errors.New("invalid workflow name")
+	errAlreadyExists = errors.New("already exists")
+)
+
+type fileStorage struct {
+	dir      string
+	registry *Registry
+	options  []WorkflowOption
+	lock     *sync.RWMutex
+}
+
+func NewFileStorage(dir string, registry *Registry, options ...WorkflowOption) (*fileStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &fileStorage{
+		dir:      dir,
+		registry: registry,
+		options:  options,
+		lock:     &sync.RWMutex{},
+	}, nil
+}
+
+func (s *fileStorage) Save(workflow *Workflow) error {
+	data, err := json.MarshalIndent(workflow.Definition(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir, err := s.path(workflow.key)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.saveLocked(workflow.key, dir, data)
+}
+
+func (s *fileStorage) Create(workflow *Workflow) error {
+	data, err := json.MarshalIndent(workflow.Definition(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir, err := s.path(workflow.key)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	version, err := s.latest(workflow.key)
+	if err != nil {
+		return err
+	}
+
+	if version > 0 {
+		return fmt.Errorf("workflow '%s' %w", workflow.key, errAlreadyExists)
+	}
+
+	return s.saveLocked(workflow.key, dir, data)
+}
+
+func (s *fileStorage) saveLocked(name string, dir string, data []byte) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	version, err := s.latest(name)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%d.json", version+1))
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+func (s *fileStorage) Get(name string) (*Workflow, error) {
+	s.lock.RLock()
+	version, err := s.latest(name)
+	s.lock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if version == 0 {
+		return nil, fmt.Errorf("workflow '%s' not found", name)
+	}
+
+	return s.GetVersion(name, version)
+}
+
+func (s *fileStorage) GetVersion(name string, version int) (*Workflow, error) {
+	dir, err := s.path(name)
+	if err != nil {
+		return nil, fmt.Errorf("workflow '%s' not found", name)
+	}
+
+	s.lock.RLock()
+	data, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("%d.json", version)))
+	s.lock.RUnlock()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("workflow '%s' version %d not found", name, version)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	definition := Definition{}
+	if err := json.Unmarshal(data, &definition); err != nil {
+		return nil, err
+	}
+
+	return s.registry.Build(definition, s.options...)
+}
+
+func (s *fileStorage) List(prefix string, offset int, limit int) ([]string, error) {
+	s.lock.RLock()
+	entries, err := os.ReadDir(s.dir)
+	s.lock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+
+		name, err := url.PathUnescape(e.Name())
+		if err != nil {
+			continue
+		}
+
+		names = append(names, name)
+	}
+
+	return paginate(names, prefix, offset, limit), nil
+}
+
+func (s *fileStorage) Delete(name string) error {
+	dir, err := s.path(name)
+	if err != nil {
+		return fmt.Errorf("workflow '%s' not found", name)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("workflow '%s' not found", name)
+	}
+
+	return os.RemoveAll(dir)
+}
+
+func (s *fileStorage) Versions(name string) ([]int, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.versions(name)
+}
+
+func (s *fileStorage) path(name string) (string, error) {
+	escaped := url.PathEscape(name)
+	if name == "" || escaped == "." || escaped == ".." {
+		return "", fmt.Errorf("%w '%s'", errInvalidName, name)
+	}
+
+	path := filepath.Join(s.dir, escaped)
+	if filepath.Dir(path) != filepath.Clean(s.dir) {
+		return "", fmt.Errorf("%w '%s'", errInvalidName, name)
+	}
+
+	return path, nil
+}
+
+func (s *fileStorage) latest(name string) (int, error) {
+	versions, err := s.versions(name)
+	if err != nil || len(versions) == 0 {
+		return 0, err
+	}
+
+	return versions[len(versions)-1], nil
+}
+
+func (s *fileStorage) versions(name string) ([]int, error) {
+	dir, err := s.path(name)
+	if err != nil {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]int, 0, len(entries))
+	for _, e := range entries {
+		v, err := strconv.Atoi(strings.TrimSuffix(e.Name(), ".json"))
+		if err != nil || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+
+	return versions, nil
+}
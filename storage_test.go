@@ -0,0 +1,331 @@
+package flow
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newStoredWorkflow(t *testing.T, name string, keys ...string) *Workflow {
+	t.Helper()
+
+	w := NewWorkflow(name)
+	nodes := make([]*Node, 0, len(keys))
+	for _, key := range keys {
+		n, err := newTestRegistry().NewNode(key, "echo")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		nodes = append(nodes, n)
+	}
+
+	w.AddNode(nodes...)
+	for i := 1; i < len(nodes); i++ {
+		if err := w.AddEdge(nodes[i-1], nodes[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return w
+}
+
+func TestFileStorageVersions(t *testing.T) {
+	storage, err := NewFileStorage(t.TempDir(), newTestRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, second := newStoredWorkflow(t, "w", "a", "b"), newStoredWorkflow(t, "w", "a", "b", "c")
+	for _, w := range []*Workflow{first, second} {
+		if err := storage.Save(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	versions, err := storage.Versions("w")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(versions, []int{1, 2}) {
+		t.Fatalf("unexpected versions %v", versions)
+	}
+
+	cases := []struct {
+		name    string
+		get     func() (*Workflow, error)
+		want    *Workflow
+		wantErr bool
+	}{
+		{name: "latest", get: func() (*Workflow, error) { return storage.Get("w") }, want: second},
+		{name: "first version", get: func() (*Workflow, error) { return storage.GetVersion("w", 1) }, want: first},
+		{name: "missing version", get: func() (*Workflow, error) { return storage.GetVersion("w", 3) }, wantErr: true},
+		{name: "missing workflow", get: func() (*Workflow, error) { return storage.Get("missing") }, wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			w, err := c.get()
+			if c.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(w.Definition(), c.want.Definition()) {
+				t.Fatalf("expected %+v, got %+v", c.want.Definition(), w.Definition())
+			}
+		})
+	}
+}
+
+func TestFileStorageNames(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "workflows")
+	storage, err := NewFileStorage(dir, newTestRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.Save(newStoredWorkflow(t, "team/w", "a", "b")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storage.Get("team/w"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"", ".", ".."} {
+		t.Run("name '"+name+"'", func(t *testing.T) {
+			if err := storage.Save(newStoredWorkflow(t, name, "a", "b")); !errors.Is(err, errInvalidName) {
+				t.Fatalf("expected invalid name on save, got %v", err)
+			}
+
+			if _, err := storage.Get(name); err == nil {
+				t.Fatal("expected get to fail")
+			}
+
+			if err := storage.Delete(name); err == nil {
+				t.Fatal("expected delete to fail")
+			}
+		})
+	}
+
+	if _, err := os.Stat(dir); err != nil {
+		t.Fatalf("storage directory was removed: %v", err)
+	}
+
+	names, err := storage.List("", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(names, []string{"team/w"}) {
+		t.Fatalf("unexpected workflows %v", names)
+	}
+}
+
+func TestInMemoryStorage(t *testing.T) {
+	storage := NewInMemoryStorage()
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			name := "user-" + strconv.Itoa(i)
+			if i%2 == 1 {
+				name = "order-" + strconv.Itoa(i)
+			}
+
+			if err := storage.Save(NewWorkflow(name)); err != nil {
+				t.Error(err)
+			}
+
+			if _, err := storage.List("", 0, 0); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	e := NewServer(storage, WithServerMetrics(NewMetrics(nil))).GetEcho()
+	cases := []struct {
+		name   string
+		prefix string
+		offset int
+		limit  int
+		query  string
+		want   []string
+	}{
+		{name: "all", want: []string{"order-1", "order-3", "order-5", "order-7", "order-9", "user-0", "user-2", "user-4", "user-6", "user-8"}},
+		{name: "prefix", prefix: "user-", query: "?prefix=user-", want: []string{"user-0", "user-2", "user-4", "user-6", "user-8"}},
+		{name: "page", prefix: "user-", offset: 1, limit: 2, query: "?prefix=user-&offset=1&limit=2", want: []string{"user-2", "user-4"}},
+		{name: "last page", prefix: "order-", offset: 4, limit: 2, query: "?prefix=order-&offset=4&limit=2", want: []string{"order-9"}},
+		{name: "offset past end", offset: 20, query: "?offset=20", want: []string{}},
+		{name: "no match", prefix: "invoice-", query: "?prefix=invoice-", want: []string{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			names, err := storage.List(c.prefix, c.offset, c.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(names, c.want) {
+				t.Fatalf("expected %v, got %v", c.want, names)
+			}
+
+			rec := request(e, http.MethodGet, "/workflows"+c.query, "")
+			out := map[string][]string{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+				t.Fatal(err)
+			}
+
+			if rec.Code != http.StatusOK || !reflect.DeepEqual(out["workflows"], c.want) {
+				t.Fatalf("expected %v from the api, got %d: %s", c.want, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	if rec := request(e, http.MethodDelete, "/workflows/user-0", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := request(e, http.MethodDelete, "/workflows/user-0", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected deleting twice to return not found, got %d", rec.Code)
+	}
+
+	if _, err := storage.Get("user-0"); err == nil {
+		t.Fatal("expected deleted workflow to be gone")
+	}
+
+	if err := storage.Delete("missing"); err == nil {
+		t.Fatal("expected deleting a missing workflow to fail")
+	}
+}
+
+func TestWorkflowEndpoints(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "workflows")
+	storage, err := NewFileStorage(dir, newTestRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := NewServer(storage, WithRegistry(newTestRegistry()), WithServerMetrics(NewMetrics(nil))).GetEcho()
+	definition := func(name string, last string) string {
+		return `{"name":"` + name + `","nodes":[{"key":"a","type":"echo"},{"key":"` + last + `","type":"echo"}],"edges":[{"from":"a","to":"` + last + `"}]}`
+	}
+
+	cases := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		want     int
+		contains string
+	}{
+		{name: "create", method: http.MethodPost, path: "/workflows", body: definition("w", "b"), want: http.StatusCreated, contains: `"to":"b"`},
+		{name: "create existing", method: http.MethodPost, path: "/workflows", body: definition("w", "b"), want: http.StatusConflict},
+		{name: "create parent directory", method: http.MethodPost, path: "/workflows", body: definition("..", "b"), want: http.StatusBadRequest},
+		{name: "create invalid definition", method: http.MethodPost, path: "/workflows", body: `{"name":"v","nodes":[{"key":"a","type":"missing"}]}`, want: http.StatusBadRequest},
+		{name: "get", method: http.MethodGet, path: "/workflows/w", want: http.StatusOK, contains: `"to":"b"`},
+		{name: "update", method: http.MethodPut, path: "/workflows/w", body: definition("w", "c"), want: http.StatusOK, contains: `"to":"c"`},
+		{name: "get updated", method: http.MethodGet, path: "/workflows/w", want: http.StatusOK, contains: `"to":"c"`},
+		{name: "update with other name", method: http.MethodPut, path: "/workflows/w", body: definition("v", "c"), want: http.StatusBadRequest},
+		{name: "get missing", method: http.MethodGet, path: "/workflows/missing", want: http.StatusNotFound},
+		{name: "delete current directory", method: http.MethodDelete, path: "/workflows/.", want: http.StatusNotFound},
+		{name: "delete parent directory", method: http.MethodDelete, path: "/workflows/..", want: http.StatusNotFound},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rec := request(e, c.method, c.path, c.body)
+			if rec.Code != c.want || !strings.Contains(rec.Body.String(), c.contains) {
+				t.Fatalf("expected status %d with %q, got %d: %s", c.want, c.contains, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	versions, err := storage.Versions("w")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(versions, []int{1, 2}) {
+		t.Fatalf("unexpected versions %v", versions)
+	}
+
+	if _, err := os.Stat(dir); err != nil {
+		t.Fatalf("storage directory was removed: %v", err)
+	}
+
+	e = NewServer(storage, WithServerMetrics(NewMetrics(nil))).GetEcho()
+	if rec := request(e, http.MethodPost, "/workflows", definition("x", "b")); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected server without registry to reject definitions, got %d", rec.Code)
+	}
+}
+
+func TestCreateWorkflowConcurrently(t *testing.T) {
+	file, err := NewFileStorage(t.TempDir(), newTestRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storages := map[string]Storage{"file": file, "memory": NewInMemoryStorage()}
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			e := NewServer(storage, WithRegistry(newTestRegistry()), WithServerMetrics(NewMetrics(nil))).GetEcho()
+
+			created := 0
+			wg := &sync.WaitGroup{}
+			lock := &sync.Mutex{}
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					rec := request(e, http.MethodPost, "/workflows", `{"name":"w","nodes":[{"key":"a","type":"echo"}]}`)
+					lock.Lock()
+					defer lock.Unlock()
+					switch rec.Code {
+					case http.StatusCreated:
+						created++
+					case http.StatusConflict:
+					default:
+						t.Errorf("unexpected status %d: %s", rec.Code, rec.Body.String())
+					}
+				}()
+			}
+			wg.Wait()
+
+			if created != 1 {
+				t.Fatalf("expected exactly one create to succeed, got %d", created)
+			}
+		})
+	}
+
+	versions, err := file.Versions("w")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(versions) != 1 {
+		t.Fatalf("expected a single version, got %v", versions)
+	}
+}
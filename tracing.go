@@ -0,0 +1,138 @@
+package flow
+
+import (
+	"context"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type tracerProviderKey struct{}
+
+const instrumentationName = "github.com/ad3n/flow-graph"
+
+func WithTracerProvider(provider trace.TracerProvider) WorkflowOption {
+	return func(w *Workflow) {
+		w.tracerProvider = provider
+	}
+}
+
+func WithServerTracerProvider(provider trace.TracerProvider) ServerOption {
+	return func(s *server) {
+		s.tracerProvider = provider
+	}
+}
+
+func withTracerProvider(ctx context.Context, provider trace.TracerProvider) context.Context {
+	if provider == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, tracerProviderKey{}, provider)
+}
+
+func extractTrace(ctx context.Context, carrier propagation.HeaderCarrier) context.Context {
+	return propagation.TraceContext{}.Extract(ctx, carrier)
+}
+
+func (w *Workflow) tracer(ctx context.Context) trace.Tracer {
+	if w.tracerProvider != nil {
+		return w.tracerProvider.Tracer(instrumentationName)
+	}
+
+	if provider, ok := ctx.Value(tracerProviderKey{}).(trace.TracerProvider); ok {
+		return provider.Tracer(instrumentationName)
+	}
+
+	return otel.GetTracerProvider().Tracer(instrumentationName)
+}
+
+func (w *Workflow) startRunSpan(ctx context.Context, runID string) (context.Context, trace.Span) {
+	return w.tracer(ctx).Start(ctx, w.key, trace.WithAttributes(
+		attribute.String("flow.workflow", w.key),
+		attribute.String("flow.run_id", runID),
+	))
+}
+
+func (w *Workflow) startNodeSpan(ctx context.Context, node *Node, step string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		attribute.String("flow.workflow", w.key),
+		attribute.String("flow.node", step),
+		attribute.String("flow.node.type", node.GetType()),
+	}
+	if state := runStateFrom(ctx); state != nil {
+		attrs = append(attrs, attribute.String("flow.run_id", state.run.ID))
+	}
+
+	return w.tracer(ctx).Start(ctx, node.key, trace.WithAttributes(attrs...))
+}
+
+func endSpan(span trace.Span, err error) {
+	failSpan(span, err)
+	span.End()
+}
+
+func failSpan(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+func (w *Workflow) traceDecision(span trace.Span, node *Node, result []byte) {
+	if !node.isConditionalNode && !node.isSwitchNode && !node.isLoopNode {
+		return
+	}
+
+	span.SetAttributes(attribute.String("flow.condition.result", string(result)))
+	if branch := w.branchTaken(node, result); branch != "" {
+		span.SetAttributes(attribute.String("flow.branch", branch))
+	}
+}
+
+func (w *Workflow) branchTaken(node *Node, result []byte) string {
+	if node.isSwitchNode {
+		if target, ok := node.cases[string(result)]; ok {
+			return target.key
+		}
+
+		if node.defaultNode != nil {
+			return node.defaultNode.key
+		}
+
+		return ""
+	}
+
+	status, err := strconv.ParseBool(string(result))
+	if node.isLoopNode {
+		if err != nil {
+			return ""
+		}
+
+		if status == (node.loopMode == DoUntil) {
+			return "exit"
+		}
+
+		return node.loopBody.key
+	}
+
+	if err != nil && node.invalidNode != nil {
+		return node.invalidNode.key
+	}
+
+	if err != nil && w.strict {
+		return ""
+	}
+
+	if status {
+		return node.next[0].key
+	}
+
+	return node.next[1].key
+}
@@ -0,0 +1,119 @@
+package flow
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	start := echoNode("start")
+	condition := NewNode("condition", func(param map[string][]byte) ([]byte, error) {
+		return []byte("true"), nil
+	})
+	yes, no := echoNode("yes"), echoNode("no")
+	b1, b2, aggregate := echoNode("b1"), echoNode("b2"), echoNode("aggregate")
+
+	w := newTestWorkflow("traced")
+	w.AddNode(start, condition, yes, no, b1, b2, aggregate)
+	if err := w.AddConditionalEdge(start, condition, yes, no); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.AddParallelEdge(yes, aggregate, b1, b2); err != nil {
+		t.Fatal(err)
+	}
+
+	storage := NewInMemoryStorage()
+	if err := storage.Save(w); err != nil {
+		t.Fatal(err)
+	}
+
+	e := NewServer(storage, WithServerTracerProvider(provider), WithServerMetrics(NewMetrics(nil))).GetEcho()
+	req := httptest.NewRequest(http.MethodPost, "/execute/traced", strings.NewReader(`{"param":"x"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+
+	root, ok := spans["traced"]
+	if !ok {
+		t.Fatalf("missing root span, got %v", spans)
+	}
+
+	if root.SpanContext.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || root.Parent.SpanID().String() != "00f067aa0ba902b7" {
+		t.Fatalf("root span does not continue the incoming trace: %+v", root.Parent)
+	}
+
+	for _, key := range []string{"start", "condition", "yes", "b1", "b2", "aggregate"} {
+		span, ok := spans[key]
+		if !ok {
+			t.Fatalf("missing span for %s", key)
+		}
+
+		if span.Parent.SpanID() != root.SpanContext.SpanID() {
+			t.Fatalf("span %s is not a child of the root span", key)
+		}
+	}
+
+	if _, ok := spans["no"]; ok {
+		t.Fatal("unexpected span for branch not taken")
+	}
+
+	attrs := map[string]string{}
+	for _, kv := range spans["condition"].Attributes {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+
+	if attrs["flow.condition.result"] != "true" || attrs["flow.branch"] != "yes" {
+		t.Fatalf("unexpected condition attributes %v", attrs)
+	}
+
+	if spans["aggregate"].StartTime.Before(spans["b1"].EndTime) || spans["aggregate"].StartTime.Before(spans["b2"].EndTime) {
+		t.Fatal("aggregate span started before the branches finished")
+	}
+}
+
+func TestTracingErrors(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	start, bad := echoNode("start"), failNode("bad")
+	w := newTestWorkflow("failing", WithTracerProvider(provider))
+	w.AddNode(start, bad)
+	if err := w.AddEdge(start, bad); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.Execute([]byte("x")); err == nil {
+		t.Fatal("expected run to fail")
+	}
+
+	statuses := map[string]codes.Code{}
+	for _, span := range exporter.GetSpans() {
+		statuses[span.Name] = span.Status.Code
+	}
+
+	want := map[string]codes.Code{"failing": codes.Error, "start": codes.Unset, "bad": codes.Error}
+	if !reflect.DeepEqual(statuses, want) {
+		t.Fatalf("expected %v, got %v", want, statuses)
+	}
+}
@@ -2,17 +2,25 @@ package flow
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
+	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/dominikbraun/graph"
 	"github.com/dominikbraun/graph/draw"
 	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 )
@@ -20,53 +28,319 @@ import (
 type (
 	action func(param map[string][]byte) ([]byte, error)
 
+	ErrorMode int
+
+	LoopMode int
+
+	JoinStrategy int
+
+	contextAction func(ctx context.Context, param map[string][]byte) ([]byte, error)
+
+	NodeOption func(n *Node)
+
+	ExportOption func(c *exportConfig)
+
+	exportConfig struct {
+		expand bool
+	}
+
+	cluster struct {
+		id       string
+		label    string
+		vertices []string
+	}
+
+	WorkflowOption func(w *Workflow)
+
 	Storage interface {
-		Save(workflow *workflow) error
-		Get(name string) (*workflow, error)
+		Save(workflow *Workflow) error
+		Get(name string) (*Workflow, error)
+		List(prefix string, offset int, limit int) ([]string, error)
+		Delete(name string) error
+	}
+
+	CreateStorage interface {
+		Storage
+		Create(workflow *Workflow) error
 	}
 
 	server struct {
-		storage Storage
-		server  *echo.Echo
+		storage        Storage
+		registry       *Registry
+		runs           *runRegistry
+		server         *echo.Echo
+		logger         Logger
+		metrics        *Metrics
+		tracerProvider trace.TracerProvider
+		lock           *sync.Mutex
 	}
 
+	ServerOption func(s *server)
+
 	inMemoryStorage struct {
-		workflows map[string]*workflow
+		lock      *sync.RWMutex
+		workflows map[string]*Workflow
 	}
 
-	node struct {
+	Node struct {
 		key               string
+		typ               string
 		isTrueNode        bool
 		isFalseNode       bool
 		isConditionalNode bool
 		isParallelNode    bool
-		action            action
-		aggregateNode     *node
-		next              []*node
+		isSwitchNode      bool
+		isLoopNode        bool
+		isMapNode         bool
+		action            contextAction
+		cases             map[string]*Node
+		defaultNode       *Node
+		invalidNode       *Node
+		loopBody          *Node
+		loopMode          LoopMode
+		maxIterations     int
+		mapNode           *Node
+		concurrency       int
+		workflow          string
+		storage           Storage
+		maxDepth          int
+		compensate        contextAction
+		errorNode         *Node
+		regionErrorNode   *Node
+		aggregateNode     *Node
+		parallelPolicy    ParallelPolicy
+		retry             RetryPolicy
+		timeout           time.Duration
+		next              []*Node
 	}
 
-	workflow struct {
+	Workflow struct {
 		key            string
 		cLock          *sync.Mutex
-		root           *node
-		availableNodes map[string]*node
+		root           *Node
+		availableNodes map[string]*Node
 		nodes          map[string]map[string]vertex
-		destinations   map[string][]*node
+		destinations   map[string][]*Node
+		loops          []vertex
+		errorEdges     []vertex
+		edges          []EdgeDefinition
+		timeout        time.Duration
+		strict         bool
+		runStore       RunStore
+		logger         Logger
+		logPayload     bool
+		redact         Redactor
+		middleware     []Middleware
+		hooks          []Hooks
+		metrics        *Metrics
+		tracerProvider trace.TracerProvider
 	}
 
 	vertex struct {
-		from  *node
-		to    *node
+		from  *Node
+		to    *Node
 		label string
+		join  bool
+	}
+
+	List struct {
+		Prefix string `query:"prefix"`
+		Offset int    `query:"offset"`
+		Limit  int    `query:"limit"`
 	}
 
 	Execute struct {
 		Param string `json:"param" form:"param"`
+		Async bool   `json:"async" form:"async"`
+	}
+
+	RunResponse struct {
+		ID       string            `json:"id"`
+		Workflow string            `json:"workflow"`
+		Status   RunStatus         `json:"status"`
+		Current  string            `json:"current"`
+		Outputs  map[string]string `json:"outputs"`
+		Result   string            `json:"result,omitempty"`
+		Error    string            `json:"error,omitempty"`
+
+		Compensations []Compensation `json:"compensations,omitempty"`
+	}
+
+	CanceledError struct {
+		Node string
+		Err  error
+	}
+
+	ConditionError struct {
+		Node  string
+		Value string
+	}
+
+	RecursionError struct {
+		Node  string
+		Stack []string
+	}
+
+	LoopLimitError struct {
+		Node          string
+		MaxIterations int
+	}
+
+	NodeError struct {
+		Node string
+		Err  error
+	}
+
+	PanicError struct {
+		Node  string
+		Value any
+		Stack []byte
+	}
+
+	TimeoutError struct {
+		Node    string
+		Timeout time.Duration
+	}
+
+	ParallelPolicy struct {
+		OnError ErrorMode
+		Join    JoinStrategy
+		Quorum  int
+	}
+
+	ParallelError struct {
+		Node   string
+		Errors map[string]error
+	}
+
+	boundaryKey struct{}
+
+	callStackKey struct{}
+
+	branchResult struct {
+		key    string
+		result []byte
+		err    error
 	}
 )
 
-func NewServer(storage Storage) *server {
+var errRunTimeout = errors.New("workflow timeout exceeded")
+
+var reservedBranchKeys = map[string]bool{"data": true, "errors": true, "branches": true, "settled": true}
+
+const DefaultMaxDepth = 16
+
+const (
+	JoinAll JoinStrategy = iota
+	JoinFirst
+	JoinQuorum
+	JoinAllSettled
+)
+
+const (
+	While LoopMode = iota
+	DoUntil
+)
+
+const (
+	FailFast ErrorMode = iota
+	WaitAll
+	ContinueOnError
+)
+
+func (e *CanceledError) Error() string {
+	return fmt.Sprintf("execution canceled at node '%s': %s", e.Node, e.Err.Error())
+}
+
+func (e *CanceledError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ConditionError) Error() string {
+	return fmt.Sprintf("condition node '%s' returned non boolean value '%s'", e.Node, e.Value)
+}
+
+func (e *RecursionError) Error() string {
+	return fmt.Sprintf("recursive workflow invocation at node '%s': %s", e.Node, strings.Join(e.Stack, " -> "))
+}
+
+func (e *NodeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *NodeError) Unwrap() error {
+	return e.Err
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("node '%s' panicked: %v", e.Node, e.Value)
+}
+
+func recoverPanic(node string, err *error) {
+	if v := recover(); v != nil {
+		*err = &PanicError{Node: node, Value: v, Stack: debug.Stack()}
+	}
+}
+
+func (e *LoopLimitError) Error() string {
+	return fmt.Sprintf("loop '%s' did not finish within %d iterations", e.Node, e.MaxIterations)
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("node '%s' timed out after %s", e.Node, e.Timeout.String())
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+func (e *ParallelError) Error() string {
+	keys := make([]string, 0, len(e.Errors))
+	for k := range e.Errors {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	messages := make([]string, 0, len(keys))
+	for _, k := range keys {
+		messages = append(messages, fmt.Sprintf("'%s': %s", k, e.Errors[k].Error()))
+	}
+
+	return fmt.Sprintf("parallel execution of '%s' failed: %s", e.Node, strings.Join(messages, "; "))
+}
+
+func (e *ParallelError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+func NewServer(storage Storage, options ...ServerOption) *server {
+	runs := newRunRegistry(storage)
+
 	e := echo.New()
+	s := &server{
+		storage: storage,
+		runs:    runs,
+		server:  e,
+		lock:    &sync.Mutex{},
+	}
+
+	for _, option := range options {
+		option(s)
+	}
+	runs.logger = s.logger
+	runs.metrics = s.metrics
+	runs.tracerProvider = s.tracerProvider
+	if s.metrics == nil {
+		s.metrics = defaultMetrics
+	}
+
+	e.GET("/metrics", echo.WrapHandler(s.metrics.Handler()))
+
 	e.POST("/execute/:workflow", func(c echo.Context) error {
 		workflow := Execute{}
 		if err := c.Bind(&workflow); err != nil {
@@ -75,6 +349,20 @@ func NewServer(storage Storage) *server {
 			})
 		}
 
+		ctx := extractTrace(c.Request().Context(), propagation.HeaderCarrier(c.Request().Header))
+		if workflow.Async {
+			id, err := runs.start(ctx, c.Param("workflow"), []byte(workflow.Param))
+			if err != nil {
+				return c.JSON(http.StatusNotFound, map[string]string{
+					"message": err.Error(),
+				})
+			}
+
+			return c.JSON(http.StatusAccepted, map[string]string{
+				"id": id,
+			})
+		}
+
 		w, err := storage.Get(c.Param("workflow"))
 		if err != nil {
 			return c.JSON(http.StatusNotFound, map[string]string{
@@ -82,7 +370,7 @@ func NewServer(storage Storage) *server {
 			})
 		}
 
-		res, err := w.Execute([]byte(workflow.Param))
+		res, err := w.ExecuteContext(runs.context(ctx), []byte(workflow.Param))
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, map[string]string{
 				"message": err.Error(),
@@ -94,6 +382,36 @@ func NewServer(storage Storage) *server {
 		})
 	})
 
+	e.GET("/workflows", func(c echo.Context) error {
+		list := List{}
+		if err := c.Bind(&list); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "invalid request.",
+			})
+		}
+
+		names, err := storage.List(list.Prefix, list.Offset, list.Limit)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		return c.JSON(http.StatusOK, map[string][]string{
+			"workflows": names,
+		})
+	})
+
+	e.DELETE("/workflows/:name", func(c echo.Context) error {
+		if err := storage.Delete(c.Param("name")); err != nil {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	})
+
 	e.GET("/export/:workflow", func(c echo.Context) error {
 		w, err := storage.Get(c.Param("workflow"))
 		if err != nil {
@@ -114,9 +432,151 @@ func NewServer(storage Storage) *server {
 		})
 	})
 
-	return &server{
-		storage: storage,
-		server:  e,
+	e.GET("/runs/:id", func(c echo.Context) error {
+		run, err := runs.get(c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		return c.JSON(http.StatusOK, newRunResponse(run))
+	})
+
+	e.DELETE("/runs/:id", func(c echo.Context) error {
+		run, err := runs.cancel(c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		return c.JSON(http.StatusAccepted, newRunResponse(run))
+	})
+
+	e.POST("/workflows", func(c echo.Context) error {
+		w, err := s.buildWorkflow(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		if err := s.create(w); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, errInvalidName) {
+				status = http.StatusBadRequest
+			}
+
+			if errors.Is(err, errAlreadyExists) {
+				status = http.StatusConflict
+			}
+
+			return c.JSON(status, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		return c.JSON(http.StatusCreated, w.Definition())
+	})
+
+	e.PUT("/workflows/:name", func(c echo.Context) error {
+		w, err := s.buildWorkflow(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		if w.key != c.Param("name") {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": fmt.Sprintf("workflow name '%s' does not match '%s'", w.key, c.Param("name")),
+			})
+		}
+
+		if err := storage.Save(w); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, errInvalidName) {
+				status = http.StatusBadRequest
+			}
+
+			return c.JSON(status, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		return c.JSON(http.StatusOK, w.Definition())
+	})
+
+	e.GET("/workflows/:name", func(c echo.Context) error {
+		w, err := storage.Get(c.Param("name"))
+		if err != nil {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		return c.JSON(http.StatusOK, w.Definition())
+	})
+
+	return s
+}
+
+func WithRegistry(registry *Registry) ServerOption {
+	return func(s *server) {
+		s.registry = registry
+	}
+}
+
+func (s *server) create(w *Workflow) error {
+	if storage, ok := s.storage.(CreateStorage); ok {
+		return storage.Create(w)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, err := s.storage.Get(w.key); err == nil {
+		return fmt.Errorf("workflow '%s' %w", w.key, errAlreadyExists)
+	}
+
+	return s.storage.Save(w)
+}
+
+func (s *server) buildWorkflow(c echo.Context) (*Workflow, error) {
+	if s.registry == nil {
+		return nil, errors.New("server has no node registry, use WithRegistry() to enable workflow definitions")
+	}
+
+	data, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return nil, err
+	}
+
+	definition, err := ParseDefinition(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.registry.Build(definition)
+}
+
+func newRunResponse(run Run) RunResponse {
+	outputs := make(map[string]string, len(run.Outputs))
+	for k, v := range run.Outputs {
+		outputs[k] = string(v)
+	}
+
+	return RunResponse{
+		ID:       run.ID,
+		Workflow: run.Workflow,
+		Status:   run.Status,
+		Current:  run.Current,
+		Outputs:  outputs,
+		Result:   string(run.Result),
+		Error:    run.Error,
+
+		Compensations: run.Compensations,
 	}
 }
 
@@ -130,18 +590,36 @@ func (s *server) GetEcho() *echo.Echo {
 
 func NewInMemoryStorage() *inMemoryStorage {
 	return &inMemoryStorage{
-		workflows: make(map[string]*workflow),
+		lock:      &sync.RWMutex{},
+		workflows: make(map[string]*Workflow),
 	}
 }
 
-func (s *inMemoryStorage) Save(workflow *workflow) error {
+func (s *inMemoryStorage) Save(workflow *Workflow) error {
+	s.lock.Lock()
+	s.workflows[workflow.key] = workflow
+	s.lock.Unlock()
+
+	return nil
+}
+
+func (s *inMemoryStorage) Create(workflow *Workflow) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.workflows[workflow.key]; ok {
+		return fmt.Errorf("workflow '%s' %w", workflow.key, errAlreadyExists)
+	}
+
 	s.workflows[workflow.key] = workflow
 
 	return nil
 }
 
-func (s *inMemoryStorage) Get(name string) (*workflow, error) {
+func (s *inMemoryStorage) Get(name string) (*Workflow, error) {
+	s.lock.RLock()
 	w, ok := s.workflows[name]
+	s.lock.RUnlock()
 	if !ok {
 		return nil, fmt.Errorf("workflow '%s' not found", name)
 	}
@@ -149,88 +627,253 @@ func (s *inMemoryStorage) Get(name string) (*workflow, error) {
 	return w, nil
 }
 
-func NewWorkflow(name string) *workflow {
-	return &workflow{
-		key:            name,
-		availableNodes: make(map[string]*node),
-		nodes:          make(map[string]map[string]vertex),
-		destinations:   make(map[string][]*node),
-		cLock:          &sync.Mutex{},
+func (s *inMemoryStorage) List(prefix string, offset int, limit int) ([]string, error) {
+	s.lock.RLock()
+	names := make([]string, 0, len(s.workflows))
+	for name := range s.workflows {
+		names = append(names, name)
 	}
-}
+	s.lock.RUnlock()
 
-func (w *workflow) Export() ([]byte, error) {
-	g := graph.New(graph.StringHash, graph.Directed(), graph.Acyclic())
+	return paginate(names, prefix, offset, limit), nil
+}
 
-	for _, n := range w.availableNodes {
-		key := strings.ReplaceAll(n.key, "-", " ")
-		key = cases.Title(language.English).String(key)
-		if n.isConditionalNode {
-			g.AddVertex(key, graph.VertexAttribute("shape", "diamond"), graph.VertexAttribute("colorscheme", "ylorbr3"), graph.VertexAttribute("style", "filled"), graph.VertexAttribute("color", "2"), graph.VertexAttribute("fillcolor", "1"))
+func (s *inMemoryStorage) Delete(name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 
-			continue
-		}
+	if _, ok := s.workflows[name]; !ok {
+		return fmt.Errorf("workflow '%s' not found", name)
+	}
 
-		if n.isTrueNode {
-			g.AddVertex(key, graph.VertexAttribute("shape", "rectangle"), graph.VertexAttribute("colorscheme", "greens3"), graph.VertexAttribute("style", "filled"), graph.VertexAttribute("color", "2"), graph.VertexAttribute("fillcolor", "1"))
+	delete(s.workflows, name)
 
-			continue
-		}
+	return nil
+}
 
-		if n.isFalseNode {
-			g.AddVertex(key, graph.VertexAttribute("shape", "rectangle"), graph.VertexAttribute("colorscheme", "reds3"), graph.VertexAttribute("style", "filled"), graph.VertexAttribute("color", "2"), graph.VertexAttribute("fillcolor", "1"))
+func paginate(names []string, prefix string, offset int, limit int) []string {
+	sort.Strings(names)
 
-			continue
+	result := make([]string, 0, len(names))
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) {
+			result = append(result, name)
 		}
-
-		g.AddVertex(key, graph.VertexAttribute("shape", "rectangle"), graph.VertexAttribute("colorscheme", "blues3"), graph.VertexAttribute("style", "filled"), graph.VertexAttribute("color", "2"), graph.VertexAttribute("fillcolor", "1"))
 	}
 
-	for from, to := range w.nodes {
-		from = strings.ReplaceAll(from, "-", " ")
-		from = cases.Title(language.English).String(from)
-		for k, v := range to {
-			k = strings.ReplaceAll(k, "-", " ")
-			k = cases.Title(language.English).String(k)
-			if v.label != "" {
-				g.AddEdge(from, k, graph.EdgeAttribute("label", v.label))
+	if offset < 0 {
+		offset = 0
+	}
 
-				continue
-			}
+	if offset >= len(result) {
+		return []string{}
+	}
 
-			g.AddEdge(from, k)
-		}
+	result = result[offset:]
+	if limit > 0 && limit < len(result) {
+		result = result[:limit]
 	}
 
-	buffer := bytes.Buffer{}
+	return result
+}
 
-	k := strings.ReplaceAll(w.key, "-", " ")
-	k = cases.Title(language.English).String(k)
+func NewWorkflow(name string, options ...WorkflowOption) *Workflow {
+	w := &Workflow{
+		key:            name,
+		availableNodes: make(map[string]*Node),
+		nodes:          make(map[string]map[string]vertex),
+		destinations:   make(map[string][]*Node),
+		cLock:          &sync.Mutex{},
+	}
 
-	err := draw.DOT(g, &buffer, draw.GraphAttribute("label", k), draw.GraphAttribute("bgcolor", "lightgrey"), draw.GraphAttribute("labelloc", "t"))
+	for _, option := range options {
+		option(w)
+	}
 
-	return buffer.Bytes(), err
+	return w
 }
 
-func (w *workflow) Execute(param []byte) ([]byte, error) {
-	return w.execute(w.root, param)
+func WithStrictConditions() WorkflowOption {
+	return func(w *Workflow) {
+		w.strict = true
+	}
 }
 
-func (w *workflow) AddNode(nodes ...*node) {
+func WithTimeout(timeout time.Duration) WorkflowOption {
+	return func(w *Workflow) {
+		w.timeout = timeout
+	}
+}
+
+func (w *Workflow) Export(options ...ExportOption) ([]byte, error) {
+	config := exportConfig{}
+	for _, option := range options {
+		option(&config)
+	}
+
+	g := graph.New(graph.StringHash, graph.Directed())
+	clusters := make([]cluster, 0)
+
+	w.draw(g, "", config, []string{w.key}, &clusters)
+
+	buffer := bytes.Buffer{}
+
+	k := strings.ReplaceAll(w.key, "-", " ")
+	k = cases.Title(language.English).String(k)
+
+	err := draw.DOT(g, &buffer, draw.GraphAttribute("label", k), draw.GraphAttribute("bgcolor", "lightgrey"), draw.GraphAttribute("labelloc", "t"))
+	if err != nil || len(clusters) == 0 {
+		return buffer.Bytes(), err
+	}
+
+	dot := strings.TrimRight(buffer.String(), "\n")
+	dot = strings.TrimSuffix(dot, "}")
+
+	subgraphs := strings.Builder{}
+	for _, c := range clusters {
+		subgraphs.WriteString(fmt.Sprintf("\tsubgraph %q {\n\t\tlabel=%q;\n\t\tstyle=\"dashed\";\n", "cluster_"+c.id, c.label))
+		for _, v := range c.vertices {
+			subgraphs.WriteString(fmt.Sprintf("\t\t%q;\n", v))
+		}
+		subgraphs.WriteString("\t}\n\n")
+	}
+
+	return []byte(dot + subgraphs.String() + "}\n"), nil
+}
+
+func (w *Workflow) draw(g graph.Graph[string, string], prefix string, config exportConfig, stack []string, clusters *[]cluster) []string {
+	vertices := make([]string, 0, len(w.availableNodes))
+	for _, n := range w.availableNodes {
+		key := prefix + title(n.key)
+		vertices = append(vertices, key)
+		if n.workflow != "" {
+			g.AddVertex(key, graph.VertexAttribute("shape", "component"), graph.VertexAttribute("colorscheme", "purples3"), graph.VertexAttribute("style", "filled"), graph.VertexAttribute("color", "2"), graph.VertexAttribute("fillcolor", "1"))
+
+			continue
+		}
+
+		if n.isLoopNode {
+			g.AddVertex(key, graph.VertexAttribute("shape", "hexagon"), graph.VertexAttribute("colorscheme", "ylorbr3"), graph.VertexAttribute("style", "filled"), graph.VertexAttribute("color", "2"), graph.VertexAttribute("fillcolor", "1"))
+
+			continue
+		}
+
+		if n.isConditionalNode || n.isSwitchNode {
+			g.AddVertex(key, graph.VertexAttribute("shape", "diamond"), graph.VertexAttribute("colorscheme", "ylorbr3"), graph.VertexAttribute("style", "filled"), graph.VertexAttribute("color", "2"), graph.VertexAttribute("fillcolor", "1"))
+
+			continue
+		}
+
+		if n.isTrueNode {
+			g.AddVertex(key, graph.VertexAttribute("shape", "rectangle"), graph.VertexAttribute("colorscheme", "greens3"), graph.VertexAttribute("style", "filled"), graph.VertexAttribute("color", "2"), graph.VertexAttribute("fillcolor", "1"))
+
+			continue
+		}
+
+		if n.isFalseNode {
+			g.AddVertex(key, graph.VertexAttribute("shape", "rectangle"), graph.VertexAttribute("colorscheme", "reds3"), graph.VertexAttribute("style", "filled"), graph.VertexAttribute("color", "2"), graph.VertexAttribute("fillcolor", "1"))
+
+			continue
+		}
+
+		g.AddVertex(key, graph.VertexAttribute("shape", "rectangle"), graph.VertexAttribute("colorscheme", "blues3"), graph.VertexAttribute("style", "filled"), graph.VertexAttribute("color", "2"), graph.VertexAttribute("fillcolor", "1"))
+	}
+
+	for from, to := range w.nodes {
+		from = prefix + title(from)
+		for k, v := range to {
+			k = prefix + title(k)
+			if v.label != "" {
+				g.AddEdge(from, k, graph.EdgeAttribute("label", v.label))
+
+				continue
+			}
+
+			g.AddEdge(from, k)
+		}
+	}
+
+	for _, v := range w.errorEdges {
+		g.AddEdge(prefix+title(v.from.key), prefix+title(v.to.key), graph.EdgeAttribute("label", v.label), graph.EdgeAttribute("style", "dashed"))
+	}
+
+	for _, v := range w.loops {
+		g.AddEdge(prefix+title(v.from.key), prefix+title(v.to.key), graph.EdgeAttribute("label", v.label))
+		g.AddEdge(prefix+title(v.to.key), prefix+title(v.from.key), graph.EdgeAttribute("label", "repeat"), graph.EdgeAttribute("style", "dashed"))
+	}
+
+	if !config.expand {
+		return vertices
+	}
+
+	for _, n := range w.availableNodes {
+		if n.workflow == "" || n.storage == nil || contains(stack, n.workflow) {
+			continue
+		}
+
+		child, err := n.storage.Get(n.workflow)
+		if err != nil || child.root == nil {
+			continue
+		}
+
+		childPrefix := prefix + title(n.key) + " / "
+		childVertices := child.draw(g, childPrefix, config, append(stack[:len(stack):len(stack)], child.key), clusters)
+		g.AddEdge(prefix+title(n.key), childPrefix+title(child.root.key), graph.EdgeAttribute("label", "calls"), graph.EdgeAttribute("style", "dashed"))
+
+		*clusters = append(*clusters, cluster{
+			id:       prefix + title(n.key),
+			label:    title(child.key),
+			vertices: childVertices,
+		})
+		vertices = append(vertices, childVertices...)
+	}
+
+	return vertices
+}
+
+func ExpandWorkflows() ExportOption {
+	return func(c *exportConfig) {
+		c.expand = true
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func title(key string) string {
+	return cases.Title(language.English).String(strings.ReplaceAll(key, "-", " "))
+}
+
+func (w *Workflow) Execute(param []byte) ([]byte, error) {
+	return w.ExecuteContext(context.Background(), param)
+}
+
+func (w *Workflow) ExecuteContext(ctx context.Context, param []byte) ([]byte, error) {
+	return w.ExecuteRun(ctx, newRunID(), param)
+}
+
+func (w *Workflow) AddNode(nodes ...*Node) {
 	for _, n := range nodes {
 		w.availableNodes[n.key] = n
 	}
 }
 
-func (w *workflow) GetRoot() *node {
+func (w *Workflow) GetRoot() *Node {
 	return w.root
 }
 
-func (w *workflow) GetName() string {
+func (w *Workflow) GetName() string {
 	return w.key
 }
 
-func (w *workflow) AddEdge(from *node, to *node) error {
+func (w *Workflow) AddEdge(from *Node, to *Node) error {
 	if !w.validateNode(from, to) {
 		return errors.New("one or more nodes are not registered, use AddNode() to register the node")
 	}
@@ -246,8 +889,11 @@ func (w *workflow) AddEdge(from *node, to *node) error {
 	w.assignRoot(from)
 
 	w.cLock.Lock()
+	join := w.detachJoin(from)
 	_, exists := w.nodes[from.key]
 	if exists {
+		w.cLock.Unlock()
+
 		return errors.New("use AddParallelEdge() to use parallel node")
 	}
 
@@ -259,12 +905,22 @@ func (w *workflow) AddEdge(from *node, to *node) error {
 		},
 	}
 	w.destinations[to.key] = append(w.destinations[to.key], from)
+	w.edges = append(w.edges, EdgeDefinition{
+		Type: SequentialEdge,
+		From: from.key,
+		To:   to.key,
+	})
+	w.attachJoin(join, to)
 	w.cLock.Unlock()
 
 	return nil
 }
 
-func (w *workflow) AddParallelEdge(from *node, aggregate *node, parallels ...*node) error {
+func (w *Workflow) AddParallelEdge(from *Node, aggregate *Node, parallels ...*Node) error {
+	return w.AddParallelEdgeWithPolicy(from, aggregate, ParallelPolicy{}, parallels...)
+}
+
+func (w *Workflow) AddParallelEdgeWithPolicy(from *Node, aggregate *Node, policy ParallelPolicy, parallels ...*Node) error {
 	if !w.validateNode(from, aggregate) {
 		return errors.New("one or more nodes are not registered, use AddNode() to register the node")
 	}
@@ -277,16 +933,32 @@ func (w *workflow) AddParallelEdge(from *node, aggregate *node, parallels ...*no
 		return err
 	}
 
+	if policy.Join == JoinQuorum && (policy.Quorum < 1 || policy.Quorum > len(parallels)) {
+		return fmt.Errorf("quorum must be between 1 and %d", len(parallels))
+	}
+
+	for _, n := range parallels {
+		if reservedBranchKeys[n.key] {
+			return fmt.Errorf("parallel node key '%s' is reserved for the aggregate input", n.key)
+		}
+	}
+
 	w.assignRoot(from)
 
 	w.cLock.Lock()
+	join := w.detachJoin(from)
 	from.isParallelNode = true
+	from.parallelPolicy = policy
 	for _, n := range parallels {
 		if err := w.isCircular(n, from); err != nil {
+			w.cLock.Unlock()
+
 			return err
 		}
 
 		if err := w.isCircular(aggregate, n); err != nil {
+			w.cLock.Unlock()
+
 			return err
 		}
 
@@ -303,6 +975,7 @@ func (w *workflow) AddParallelEdge(from *node, aggregate *node, parallels ...*no
 			aggregate.key: {
 				from: n,
 				to:   aggregate,
+				join: true,
 			},
 		}
 
@@ -314,12 +987,22 @@ func (w *workflow) AddParallelEdge(from *node, aggregate *node, parallels ...*no
 	from.aggregateNode = aggregate
 
 	w.destinations[aggregate.key] = append(w.destinations[aggregate.key], from)
+	w.edges = append(w.edges, EdgeDefinition{
+		Type:      ParallelEdge,
+		From:      from.key,
+		Aggregate: aggregate.key,
+		Parallels: keys(parallels),
+		OnError:   policy.OnError.String(),
+		Join:      policy.Join.String(),
+		Quorum:    policy.Quorum,
+	})
+	w.attachJoin(join, aggregate)
 	w.cLock.Unlock()
 
 	return nil
 }
 
-func (w *workflow) AddConditionalEdge(from *node, condition *node, trueNode *node, falseNode *node) error {
+func (w *Workflow) AddConditionalEdge(from *Node, condition *Node, trueNode *Node, falseNode *Node) error {
 	if !w.validateNode(from, condition, trueNode, falseNode) {
 		return errors.New("one or more nodes are not registered, use AddNode() to register the node")
 	}
@@ -336,9 +1019,16 @@ func (w *workflow) AddConditionalEdge(from *node, condition *node, trueNode *nod
 		return err
 	}
 
+	for _, n := range []*Node{trueNode, falseNode} {
+		if err := w.isCircular(n, condition); err != nil {
+			return err
+		}
+	}
+
 	w.assignRoot(from)
 
 	w.cLock.Lock()
+	join := w.detachJoin(from)
 
 	condition.isConditionalNode = true
 
@@ -347,7 +1037,7 @@ func (w *workflow) AddConditionalEdge(from *node, condition *node, trueNode *nod
 	trueNode.isTrueNode = true
 	falseNode.isFalseNode = true
 
-	condition.next = []*node{trueNode, falseNode}
+	condition.next = []*Node{trueNode, falseNode}
 
 	w.nodes[from.key] = map[string]vertex{
 		condition.key: {
@@ -371,170 +1061,1039 @@ func (w *workflow) AddConditionalEdge(from *node, condition *node, trueNode *nod
 		label: "false",
 	}
 	w.destinations[falseNode.key] = append(w.destinations[falseNode.key], from)
+	w.edges = append(w.edges, EdgeDefinition{
+		Type:      ConditionalEdge,
+		From:      from.key,
+		Condition: condition.key,
+		True:      trueNode.key,
+		False:     falseNode.key,
+	})
+	w.attachJoin(join, trueNode, falseNode)
 	w.cLock.Unlock()
 
 	return nil
 }
 
-func (w *workflow) assignRoot(node *node) {
-	if w.root == nil {
-		w.root = node
+func (w *Workflow) AddConditionErrorEdge(condition *Node, errorNode *Node) error {
+	if !w.validateNode(condition, errorNode) {
+		return errors.New("one or more nodes are not registered, use AddNode() to register the node")
 	}
-}
 
-func (w *workflow) validateNode(nodes ...*node) bool {
-	for _, n := range nodes {
-		_, ok := w.availableNodes[n.key]
-		if !ok {
-			return ok
-		}
+	if !condition.isConditionalNode {
+		return fmt.Errorf("node '%s' is not a condition, use AddConditionalEdge() first", condition.key)
 	}
 
-	return true
+	if err := w.isCircular(errorNode, condition); err != nil {
+		return err
+	}
+
+	w.cLock.Lock()
+	condition.invalidNode = errorNode
+	errorNode.isFalseNode = true
+	w.nodes[condition.key][errorNode.key] = vertex{
+		from:  condition,
+		to:    errorNode,
+		label: "invalid",
+	}
+	w.destinations[errorNode.key] = append(w.destinations[errorNode.key], condition)
+	w.edges = append(w.edges, EdgeDefinition{
+		Type: ConditionErrorEdge,
+		From: condition.key,
+		To:   errorNode.key,
+	})
+	w.cLock.Unlock()
+
+	return nil
 }
 
-func (w *workflow) isCircular(to *node, from *node) error {
-	if w.root != nil && to.key == w.root.key {
-		return fmt.Errorf("circular detection from '%s' to '%s'", from.key, w.root.key)
+func (w *Workflow) AddErrorEdge(from *Node, handler *Node) error {
+	return w.addErrorEdge(ErrorEdge, from, handler)
+}
+
+func (w *Workflow) AddErrorRegionEdge(from *Node, handler *Node) error {
+	return w.addErrorEdge(ErrorRegionEdge, from, handler)
+}
+
+func (w *Workflow) addErrorEdge(typ string, from *Node, handler *Node) error {
+	if !w.validateNode(from, handler) {
+		return errors.New("one or more nodes are not registered, use AddNode() to register the node")
 	}
 
-	froms, ok := w.destinations[to.key]
-	if !ok {
-		return nil
+	if from == handler {
+		return fmt.Errorf("node '%s' can not handle its own error", from.key)
 	}
 
-	for _, n := range froms {
-		if n.key == from.key {
-			return fmt.Errorf("circular detection from '%s' to '%s'", from.key, to.key)
-		}
+	if err := w.isCircular(handler, from); err != nil {
+		return err
 	}
 
+	label := "error"
+	w.cLock.Lock()
+	if typ == ErrorRegionEdge {
+		label = "error region"
+		from.regionErrorNode = handler
+	} else {
+		from.errorNode = handler
+	}
+
+	handler.isFalseNode = true
+	w.errorEdges = append(w.errorEdges, vertex{
+		from:  from,
+		to:    handler,
+		label: label,
+	})
+	w.destinations[handler.key] = append(w.destinations[handler.key], from)
+	w.edges = append(w.edges, EdgeDefinition{
+		Type: typ,
+		From: from.key,
+		To:   handler.key,
+	})
+	w.cLock.Unlock()
+
 	return nil
 }
 
-func (w *workflow) execute(node *node, param []byte) ([]byte, error) {
-	var result []byte
-	var err error
+func (w *Workflow) AddWhileEdge(from *Node, condition *Node, body *Node, maxIterations int) error {
+	return w.addLoopEdge(from, condition, body, maxIterations, While)
+}
 
-	result, err = node.action(map[string][]byte{"data": param})
-	log.Printf("execute %s with param %s", node.key, string(param))
-	if len(node.next) > 0 {
-		for k := 0; k < len(node.next); k++ {
-			if node.next[k].isConditionalNode {
-				result, err = w.executeCondition(node.next[k], result)
-				if err != nil {
-					return nil, err
-				}
+func (w *Workflow) AddDoUntilEdge(from *Node, condition *Node, body *Node, maxIterations int) error {
+	return w.addLoopEdge(from, condition, body, maxIterations, DoUntil)
+}
 
-				continue
-			}
+func (w *Workflow) addLoopEdge(from *Node, condition *Node, body *Node, maxIterations int, mode LoopMode) error {
+	if !w.validateNode(from, condition, body) {
+		return errors.New("one or more nodes are not registered, use AddNode() to register the node")
+	}
 
-			if node.next[k].isParallelNode {
-				result, err = w.executeParallel(node.next[k], result)
-				if err != nil {
-					return nil, err
-				}
+	if maxIterations < 1 {
+		return errors.New("loop requires a maximum iteration count of at least 1")
+	}
 
-				continue
-			}
+	if err := w.isCircular(condition, from); err != nil {
+		return err
+	}
 
-			result, err = w.execute(node.next[k], result)
-			if err != nil {
-				return nil, err
-			}
-		}
+	if err := w.isCircular(body, from); err != nil {
+		return err
 	}
 
-	return result, err
+	if err := w.isCircular(body, condition); err != nil {
+		return err
+	}
+
+	if body.key == condition.key || body.key == from.key {
+		return errors.New("circular reference detected")
+	}
+
+	w.assignRoot(from)
+
+	w.cLock.Lock()
+	join := w.detachJoin(from)
+	_, exists := w.nodes[from.key]
+	if exists {
+		w.cLock.Unlock()
+
+		return errors.New("use AddParallelEdge() to use parallel node")
+	}
+
+	condition.isLoopNode = true
+	condition.loopBody = body
+	condition.loopMode = mode
+	condition.maxIterations = maxIterations
+
+	from.next = append(from.next, condition)
+	w.nodes[from.key] = map[string]vertex{
+		condition.key: {
+			from: from,
+			to:   condition,
+		},
+	}
+	w.destinations[condition.key] = append(w.destinations[condition.key], from)
+
+	label := fmt.Sprintf("while (max %d)", maxIterations)
+	edge := WhileEdge
+	if mode == DoUntil {
+		label = fmt.Sprintf("do until (max %d)", maxIterations)
+		edge = DoUntilEdge
+	}
+
+	w.loops = append(w.loops, vertex{
+		from:  condition,
+		to:    body,
+		label: label,
+	})
+	w.edges = append(w.edges, EdgeDefinition{
+		Type:          edge,
+		From:          from.key,
+		Condition:     condition.key,
+		Body:          body.key,
+		MaxIterations: maxIterations,
+	})
+	w.attachJoin(join, condition)
+	w.cLock.Unlock()
+
+	return nil
 }
 
-func (w *workflow) executeParallel(vertex *node, param []byte) ([]byte, error) {
-	result := make(chan []byte)
-	var err error
+func (w *Workflow) AddMapEdge(from *Node, splitter *Node, mapper *Node, reducer *Node, concurrency int) error {
+	if !w.validateNode(from, splitter, mapper, reducer) {
+		return errors.New("one or more nodes are not registered, use AddNode() to register the node")
+	}
 
-	res, err := vertex.action(map[string][]byte{"data": param})
-	log.Printf("execute %s with param %s", vertex.key, string(param))
-	if err != nil {
-		return nil, err
+	if err := w.isCircular(splitter, from); err != nil {
+		return err
 	}
 
-	wg := sync.WaitGroup{}
-	for _, n := range vertex.next {
-		wg.Add(1)
-		go func(n *node) {
-			r, _ := n.action(map[string][]byte{"data": res})
-			log.Printf("execute %s with param %s", n.key, string(param))
+	if err := w.isCircular(mapper, splitter); err != nil {
+		return err
+	}
 
-			result <- r
-		}(n)
+	if err := w.isCircular(reducer, mapper); err != nil {
+		return err
 	}
 
-	rAggregate := make(map[string][]byte)
-	for _, n := range vertex.next {
-		rAggregate[n.key] = <-result
-		wg.Done()
+	w.assignRoot(from)
+
+	w.cLock.Lock()
+	join := w.detachJoin(from)
+	_, exists := w.nodes[from.key]
+	if exists {
+		w.cLock.Unlock()
+
+		return errors.New("use AddParallelEdge() to use parallel node")
 	}
-	wg.Wait()
-	close(result)
 
-	rAggregate["data"] = res
+	splitter.isMapNode = true
+	splitter.mapNode = mapper
+	splitter.aggregateNode = reducer
+	splitter.concurrency = concurrency
 
-	res, err = vertex.aggregateNode.action(rAggregate)
-	log.Printf("execute %s with param %s", vertex.aggregateNode.key, string(param))
-	if err != nil {
-		return nil, err
+	from.next = append(from.next, splitter)
+
+	w.nodes[from.key] = map[string]vertex{
+		splitter.key: {
+			from: from,
+			to:   splitter,
+		},
 	}
 
-	if vertex.aggregateNode.next[0].isConditionalNode {
-		return w.executeCondition(vertex.aggregateNode.next[0], res)
+	label := "for each"
+	if concurrency > 0 {
+		label = fmt.Sprintf("for each (max %d)", concurrency)
 	}
 
-	if vertex.aggregateNode.next[0].isParallelNode {
-		return w.executeParallel(vertex.aggregateNode.next[0], res)
+	w.nodes[splitter.key] = map[string]vertex{
+		mapper.key: {
+			from:  splitter,
+			to:    mapper,
+			label: label,
+		},
+	}
+
+	w.nodes[mapper.key] = map[string]vertex{
+		reducer.key: {
+			from:  mapper,
+			to:    reducer,
+			label: "reduce",
+		},
 	}
 
-	return w.execute(vertex.aggregateNode.next[0], res)
+	w.destinations[splitter.key] = append(w.destinations[splitter.key], from)
+	w.destinations[mapper.key] = append(w.destinations[mapper.key], splitter)
+	w.destinations[reducer.key] = append(w.destinations[reducer.key], mapper, splitter)
+	w.edges = append(w.edges, EdgeDefinition{
+		Type:        MapEdge,
+		From:        from.key,
+		Splitter:    splitter.key,
+		Mapper:      mapper.key,
+		Reducer:     reducer.key,
+		Concurrency: concurrency,
+	})
+	w.attachJoin(join, reducer)
+	w.cLock.Unlock()
+
+	return nil
 }
 
-func (w *workflow) executeCondition(node *node, param []byte) ([]byte, error) {
-	res, err := node.action(map[string][]byte{"data": param})
-	log.Printf("execute %s with param %s", node.key, string(param))
-	if err != nil {
-		return nil, err
+func (w *Workflow) AddSwitchEdge(from *Node, condition *Node, cases map[string]*Node, defaultNode *Node) error {
+	if defaultNode == nil {
+		return errors.New("switch edge requires a default node")
 	}
 
-	status, _ := strconv.ParseBool(string(res))
-	if status {
-		if node.next[0].isParallelNode {
-			return w.executeParallel(node.next[0], param)
-		}
+	if len(cases) == 0 {
+		return errors.New("switch edge requires at least one case")
+	}
 
-		if node.next[0].isConditionalNode {
-			return w.executeCondition(node.next[0], param)
+	targets := make([]*Node, 0, len(cases)+1)
+	values := make([]string, 0, len(cases))
+	for value, n := range cases {
+		if n == nil {
+			return fmt.Errorf("switch case '%s' has no node", value)
 		}
 
-		return w.execute(node.next[0], param)
+		values = append(values, value)
 	}
+	sort.Strings(values)
 
-	if node.next[1].isParallelNode {
-		return w.executeParallel(node.next[1], param)
+	for _, value := range values {
+		targets = append(targets, cases[value])
 	}
+	targets = append(targets, defaultNode)
 
-	if node.next[1].isConditionalNode {
-		return w.executeCondition(node.next[1], param)
+	if !w.validateNode(append([]*Node{from, condition}, targets...)...) {
+		return errors.New("one or more nodes are not registered, use AddNode() to register the node")
 	}
 
-	return w.execute(node.next[1], param)
-}
-
-func NewNode(key string, param action) *node {
-	return &node{
-		key:    key,
-		action: param,
-		next:   make([]*node, 0),
+	if err := w.isCircular(condition, from); err != nil {
+		return err
 	}
+
+	for _, n := range targets {
+		if err := w.isCircular(n, from); err != nil {
+			return err
+		}
+
+		if err := w.isCircular(n, condition); err != nil {
+			return err
+		}
+	}
+
+	w.assignRoot(from)
+
+	w.cLock.Lock()
+	join := w.detachJoin(from)
+
+	condition.isSwitchNode = true
+	condition.cases = make(map[string]*Node, len(cases))
+	condition.defaultNode = defaultNode
+	condition.next = targets
+
+	from.next = append(from.next, condition)
+
+	w.nodes[from.key] = map[string]vertex{
+		condition.key: {
+			from: from,
+			to:   condition,
+		},
+	}
+
+	w.nodes[condition.key] = make(map[string]vertex)
+	caseKeys := make(map[string]string, len(cases))
+	for _, value := range values {
+		n := cases[value]
+		condition.cases[value] = n
+		caseKeys[value] = n.key
+
+		label := value
+		if v, exists := w.nodes[condition.key][n.key]; exists {
+			label = v.label + ", " + value
+		}
+
+		w.nodes[condition.key][n.key] = vertex{
+			from:  condition,
+			to:    n,
+			label: label,
+		}
+		w.destinations[n.key] = append(w.destinations[n.key], from)
+	}
+
+	label := "default"
+	if v, exists := w.nodes[condition.key][defaultNode.key]; exists {
+		label = v.label + ", default"
+	}
+
+	w.nodes[condition.key][defaultNode.key] = vertex{
+		from:  condition,
+		to:    defaultNode,
+		label: label,
+	}
+	w.destinations[defaultNode.key] = append(w.destinations[defaultNode.key], from)
+	w.edges = append(w.edges, EdgeDefinition{
+		Type:      SwitchEdge,
+		From:      from.key,
+		Condition: condition.key,
+		Cases:     caseKeys,
+		Default:   defaultNode.key,
+	})
+	w.attachJoin(join, targets...)
+	w.cLock.Unlock()
+
+	return nil
+}
+
+func (w *Workflow) detachJoin(from *Node) *Node {
+	if len(w.nodes[from.key]) != 1 {
+		return nil
+	}
+
+	for _, v := range w.nodes[from.key] {
+		if !v.join {
+			return nil
+		}
+
+		delete(w.nodes, from.key)
+
+		return v.to
+	}
+
+	return nil
+}
+
+func (w *Workflow) attachJoin(aggregate *Node, nodes ...*Node) {
+	if aggregate == nil {
+		return
+	}
+
+	for _, n := range nodes {
+		if n.key == aggregate.key || len(w.nodes[n.key]) > 0 {
+			continue
+		}
+
+		w.nodes[n.key] = map[string]vertex{
+			aggregate.key: {
+				from: n,
+				to:   aggregate,
+				join: true,
+			},
+		}
+	}
+}
+
+func (w *Workflow) assignRoot(node *Node) {
+	if w.root == nil {
+		w.root = node
+	}
+}
+
+func (w *Workflow) validateNode(nodes ...*Node) bool {
+	for _, n := range nodes {
+		_, ok := w.availableNodes[n.key]
+		if !ok {
+			return ok
+		}
+	}
+
+	return true
+}
+
+func (w *Workflow) isCircular(to *Node, from *Node) error {
+	if w.root != nil && to.key == w.root.key {
+		return fmt.Errorf("circular detection from '%s' to '%s'", from.key, w.root.key)
+	}
+
+	if w.reaches(to.key, from.key, make(map[string]bool)) {
+		return fmt.Errorf("circular detection from '%s' to '%s'", from.key, to.key)
+	}
+
+	froms, ok := w.destinations[to.key]
+	if !ok {
+		return nil
+	}
+
+	for _, n := range froms {
+		if n.key == from.key {
+			return fmt.Errorf("circular detection from '%s' to '%s'", from.key, to.key)
+		}
+	}
+
+	return nil
+}
+
+func (w *Workflow) reaches(from string, to string, visited map[string]bool) bool {
+	if from == to {
+		return true
+	}
+
+	if visited[from] {
+		return false
+	}
+	visited[from] = true
+
+	for next := range w.nodes[from] {
+		if w.reaches(next, to, visited) {
+			return true
+		}
+	}
+
+	for _, v := range w.loops {
+		if v.from.key == from && w.reaches(v.to.key, to, visited) {
+			return true
+		}
+	}
+
+	for _, v := range w.errorEdges {
+		if v.from.key == from && w.reaches(v.to.key, to, visited) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (w *Workflow) run(ctx context.Context, node *Node, param map[string][]byte) (res []byte, err error) {
+	state := runStateFrom(ctx)
+	step := stepKey(ctx, node.key)
+	ctx, span := w.startNodeSpan(ctx, node, step)
+	defer func() { endSpan(span, err) }()
+
+	if result, ok := state.checkpoint(step); ok {
+		w.log(ctx).Info(ctx, "node restored from checkpoint", w.fields(ctx, Field{Key: "node", Value: step}, Field{Key: "outcome", Value: "restored"})...)
+		state.track(node, step, param, result)
+		span.SetAttributes(attribute.Bool("flow.restored", true))
+		w.traceDecision(span, node, result)
+
+		return result, nil
+	}
+
+	if err := state.start(step); err != nil {
+		return nil, w.fault(ctx, node, err)
+	}
+
+	attempts := node.retry.attempts()
+	for attempt := 1; ; attempt++ {
+		event := w.nodeEvent(ctx, step, attempt, param)
+		w.nodeStart(ctx, event)
+		span.SetAttributes(attribute.Int("flow.attempt", attempt))
+
+		started := time.Now()
+		result, err := w.attempt(ctx, node, attempt, param)
+		retry := err != nil && attempt < attempts && node.retry.retryable(err)
+		event.Result, event.Err, event.Duration = result, err, time.Since(started)
+		w.logAttempt(ctx, step, attempt, event.Duration, param, result, err, retry)
+		w.observeAttempt(ctx, node, event.Duration, err, retry)
+		w.nodeEnd(ctx, event, !retry)
+		if err == nil {
+			if err := state.complete(step, result); err != nil {
+				return nil, w.fault(ctx, node, err)
+			}
+			state.track(node, step, param, result)
+			w.traceDecision(span, node, result)
+
+			return result, nil
+		}
+
+		if !retry {
+			return nil, &NodeError{Node: node.key, Err: err}
+		}
+
+		if err := node.retry.wait(ctx, attempt); err != nil {
+			return nil, &NodeError{Node: node.key, Err: w.interrupted(ctx, node)}
+		}
+	}
+}
+
+func (w *Workflow) attempt(ctx context.Context, node *Node, attempt int, param map[string][]byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, w.interrupted(ctx, node)
+	}
+
+	actionCtx, cancel := ctx, context.CancelFunc(func() {})
+	if node.timeout > 0 {
+		actionCtx, cancel = context.WithTimeout(ctx, node.timeout)
+	}
+	defer cancel()
+
+	handler := w.handler()
+	done := make(chan branchResult, 1)
+	go func() {
+		r, err := invoke(actionCtx, handler, node, param)
+
+		done <- branchResult{key: node.key, result: r, err: err}
+	}()
+
+	select {
+	case result := <-done:
+		if ctx.Err() != nil {
+			return nil, w.interrupted(ctx, node)
+		}
+
+		return result.result, result.err
+	case <-actionCtx.Done():
+		if ctx.Err() != nil {
+			return nil, w.interrupted(ctx, node)
+		}
+
+		return nil, &TimeoutError{Node: node.key, Timeout: node.timeout}
+	}
+}
+
+func (w *Workflow) interrupted(ctx context.Context, node *Node) error {
+	if errors.Is(context.Cause(ctx), errRunTimeout) {
+		return &TimeoutError{Node: node.key, Timeout: w.timeout}
+	}
+
+	return &CanceledError{Node: node.key, Err: ctx.Err()}
+}
+
+func (w *Workflow) execute(ctx context.Context, node *Node, param []byte) ([]byte, error) {
+	result, err := w.run(ctx, node, map[string][]byte{"data": param})
+	if err != nil {
+		return nil, err
+	}
+
+	for k := 0; k < len(node.next); k++ {
+		result, err = w.dispatch(ctx, node.next[k], result)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, err
+}
+
+func (w *Workflow) dispatch(ctx context.Context, node *Node, param []byte) ([]byte, error) {
+	if boundary, _ := ctx.Value(boundaryKey{}).(*Node); boundary == node {
+		return param, nil
+	}
+
+	result, err := w.route(ctx, node, param)
+	if err != nil {
+		return w.catch(ctx, node, param, err)
+	}
+
+	return result, nil
+}
+
+func (w *Workflow) catch(ctx context.Context, node *Node, param []byte, err error) ([]byte, error) {
+	if ctx.Err() != nil {
+		return nil, err
+	}
+
+	failed := node.key
+	var nodeErr *NodeError
+	if errors.As(err, &nodeErr) {
+		failed = nodeErr.Node
+	}
+
+	if node.errorNode != nil && failed == node.key {
+		return w.handleError(ctx, node.errorNode, failed, param, err)
+	}
+
+	if node.regionErrorNode != nil {
+		return w.handleError(ctx, node.regionErrorNode, failed, param, err)
+	}
+
+	return nil, err
+}
+
+func (w *Workflow) protectedDispatch(ctx context.Context, node *Node, param []byte) (result []byte, err error) {
+	defer recoverPanic(node.key, &err)
+
+	return w.dispatch(ctx, node, param)
+}
+
+func invoke(ctx context.Context, handler NodeHandler, node *Node, param map[string][]byte) (result []byte, err error) {
+	defer recoverPanic(node.key, &err)
+
+	return handler(ctx, node, param)
+}
+
+func (w *Workflow) handleError(ctx context.Context, handler *Node, failed string, param []byte, cause error) ([]byte, error) {
+	w.log(ctx).Warn(ctx, "node error routed to handler", w.fields(ctx, Field{Key: "node", Value: stepKey(ctx, failed)}, Field{Key: "handler", Value: handler.key}, Field{Key: "error", Value: cause.Error()})...)
+
+	result, err := w.run(ctx, handler, map[string][]byte{"data": param, "error": []byte(cause.Error()), "node": []byte(failed)})
+	if err != nil {
+		return nil, err
+	}
+
+	for k := 0; k < len(handler.next); k++ {
+		result, err = w.dispatch(ctx, handler.next[k], result)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func (w *Workflow) route(ctx context.Context, node *Node, param []byte) ([]byte, error) {
+	if node.isConditionalNode {
+		return w.executeCondition(ctx, node, param)
+	}
+
+	if node.isSwitchNode {
+		return w.executeSwitch(ctx, node, param)
+	}
+
+	if node.isLoopNode {
+		return w.executeLoop(ctx, node, param)
+	}
+
+	if node.isMapNode {
+		return w.executeMap(ctx, node, param)
+	}
+
+	if node.isParallelNode {
+		return w.executeParallel(ctx, node, param)
+	}
+
+	return w.execute(ctx, node, param)
+}
+
+func (w *Workflow) executeParallel(ctx context.Context, vertex *Node, param []byte) ([]byte, error) {
+	res, err := w.run(ctx, vertex, map[string][]byte{"data": param})
+	if err != nil {
+		return nil, err
+	}
+
+	branchCtx, cancel := context.WithCancel(ctx)
+	wg := &sync.WaitGroup{}
+	drain := func() {
+		cancel()
+		wg.Wait()
+	}
+	defer drain()
+
+	result := make(chan branchResult, len(vertex.next))
+	for _, n := range vertex.next {
+		wg.Add(1)
+		go func(n *Node) {
+			defer wg.Done()
+			defer w.branchStarted(ctx, vertex)()
+
+			r, err := w.protectedDispatch(context.WithValue(branchCtx, boundaryKey{}, vertex.aggregateNode), n, res)
+
+			result <- branchResult{key: n.key, result: r, err: err}
+		}(n)
+	}
+
+	policy := vertex.parallelPolicy
+	needed := len(vertex.next)
+	switch policy.Join {
+	case JoinFirst:
+		needed = 1
+	case JoinQuorum:
+		needed = policy.Quorum
+	}
+
+	rAggregate := make(map[string][]byte)
+	errs := make(map[string]error)
+	for received := 0; received < len(vertex.next) && len(rAggregate) < needed; received++ {
+		r := <-result
+		if r.err == nil {
+			rAggregate[r.key] = r.result
+
+			continue
+		}
+
+		errs[r.key] = r.err
+		switch policy.Join {
+		case JoinAllSettled:
+			continue
+		case JoinFirst, JoinQuorum:
+			if len(vertex.next)-len(errs) < needed {
+				return nil, &NodeError{Node: vertex.key, Err: &ParallelError{Node: vertex.key, Errors: errs}}
+			}
+
+			continue
+		}
+
+		if policy.OnError == FailFast {
+			return nil, &NodeError{Node: vertex.key, Err: &ParallelError{Node: vertex.key, Errors: errs}}
+		}
+	}
+	drain()
+
+	if len(errs) > 0 {
+		if policy.Join == JoinAll && policy.OnError != ContinueOnError {
+			return nil, &NodeError{Node: vertex.key, Err: &ParallelError{Node: vertex.key, Errors: errs}}
+		}
+
+		messages := make(map[string]string, len(errs))
+		for k, e := range errs {
+			messages[k] = e.Error()
+		}
+
+		rAggregate["errors"], _ = json.Marshal(messages)
+	}
+
+	branches := make([]string, 0, len(rAggregate))
+	settled := make(map[string]string, len(vertex.next))
+	for _, n := range vertex.next {
+		if _, ok := rAggregate[n.key]; ok {
+			branches = append(branches, n.key)
+			settled[n.key] = "fulfilled"
+		}
+
+		if _, ok := errs[n.key]; ok {
+			settled[n.key] = "rejected"
+		}
+	}
+
+	rAggregate["branches"], _ = json.Marshal(branches)
+	if policy.Join == JoinAllSettled {
+		rAggregate["settled"], _ = json.Marshal(settled)
+	}
+
+	rAggregate["data"] = res
+
+	return w.join(ctx, vertex.aggregateNode, rAggregate)
+}
+
+func (w *Workflow) join(ctx context.Context, node *Node, param map[string][]byte) ([]byte, error) {
+	res, err := w.run(ctx, node, param)
+	for k := 0; err == nil && k < len(node.next); k++ {
+		res, err = w.dispatch(ctx, node.next[k], res)
+	}
+
+	if err != nil {
+		return w.catch(ctx, node, param["data"], err)
+	}
+
+	return res, nil
+}
+
+func (w *Workflow) executeCondition(ctx context.Context, node *Node, param []byte) ([]byte, error) {
+	res, err := w.run(ctx, node, map[string][]byte{"data": param})
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := strconv.ParseBool(string(res))
+	if err != nil && node.invalidNode != nil {
+		return w.dispatch(ctx, node.invalidNode, param)
+	}
+
+	if err != nil && w.strict {
+		return nil, w.fault(ctx, node, &ConditionError{Node: node.key, Value: string(res)})
+	}
+
+	if status {
+		return w.dispatch(ctx, node.next[0], param)
+	}
+
+	return w.dispatch(ctx, node.next[1], param)
+}
+
+func (w *Workflow) executeLoop(ctx context.Context, node *Node, param []byte) ([]byte, error) {
+	result := param
+	for i := 0; ; i++ {
+		iterationCtx := withScope(ctx, fmt.Sprintf("%s#%d/", node.key, i))
+		if node.loopMode == While || i > 0 {
+			res, err := w.run(iterationCtx, node, map[string][]byte{"data": result})
+			if err != nil {
+				return nil, err
+			}
+
+			status, err := strconv.ParseBool(string(res))
+			if err != nil {
+				return nil, w.fault(iterationCtx, node, &ConditionError{Node: node.key, Value: string(res)})
+			}
+
+			if status == (node.loopMode == DoUntil) {
+				break
+			}
+		}
+
+		if i >= node.maxIterations {
+			return nil, w.fault(ctx, node, &LoopLimitError{Node: node.key, MaxIterations: node.maxIterations})
+		}
+
+		res, err := w.dispatch(iterationCtx, node.loopBody, result)
+		if err != nil {
+			return nil, err
+		}
+
+		result = res
+	}
+
+	for k := 0; k < len(node.next); k++ {
+		res, err := w.dispatch(ctx, node.next[k], result)
+		if err != nil {
+			return nil, err
+		}
+
+		result = res
+	}
+
+	return result, nil
+}
+
+func (w *Workflow) executeMap(ctx context.Context, node *Node, param []byte) ([]byte, error) {
+	res, err := w.run(ctx, node, map[string][]byte{"data": param})
+	if err != nil {
+		return nil, err
+	}
+
+	items := []json.RawMessage{}
+	if err := json.Unmarshal(res, &items); err != nil {
+		return nil, w.fault(ctx, node, fmt.Errorf("splitter node '%s' must return a json array: %w", node.key, err))
+	}
+
+	mapCtx, cancel := context.WithCancel(ctx)
+	wg := &sync.WaitGroup{}
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	concurrency := node.concurrency
+	if concurrency <= 0 || concurrency > len(items) {
+		concurrency = len(items)
+	}
+
+	semaphore := make(chan struct{}, concurrency)
+	result := make(chan branchResult, len(items))
+	for i, item := range items {
+		value := []byte(item)
+		unquoted := ""
+		if json.Unmarshal(item, &unquoted) == nil {
+			value = []byte(unquoted)
+		}
+
+		wg.Add(1)
+		go func(i int, value []byte) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			defer w.branchStarted(ctx, node)()
+
+			r, err := w.protectedDispatch(withScope(mapCtx, fmt.Sprintf("%s[%d]/", node.key, i)), node.mapNode, value)
+
+			result <- branchResult{key: strconv.Itoa(i), result: r, err: err}
+		}(i, value)
+	}
+
+	rReduce := make(map[string][]byte, len(items)+2)
+	outputs := make([]string, len(items))
+	for range items {
+		r := <-result
+		if r.err != nil {
+			return nil, &NodeError{Node: node.key, Err: &ParallelError{Node: node.key, Errors: map[string]error{r.key: r.err}}}
+		}
+
+		i, _ := strconv.Atoi(r.key)
+		outputs[i] = string(r.result)
+		rReduce[r.key] = r.result
+	}
+
+	rReduce["items"], _ = json.Marshal(outputs)
+	rReduce["data"] = param
+
+	return w.join(ctx, node.aggregateNode, rReduce)
+}
+
+func (w *Workflow) executeSwitch(ctx context.Context, node *Node, param []byte) ([]byte, error) {
+	res, err := w.run(ctx, node, map[string][]byte{"data": param})
+	if err != nil {
+		return nil, err
+	}
+
+	target, ok := node.cases[string(res)]
+	if !ok {
+		target = node.defaultNode
+	}
+
+	return w.dispatch(ctx, target, param)
+}
+
+func WithNodeTimeout(timeout time.Duration) NodeOption {
+	return func(n *Node) {
+		n.timeout = timeout
+	}
+}
+
+func NewNode(key string, param action, options ...NodeOption) *Node {
+	return NewNodeWithContext(key, func(_ context.Context, p map[string][]byte) ([]byte, error) {
+		return param(p)
+	}, options...)
+}
+
+func NewNodeWithContext(key string, param contextAction, options ...NodeOption) *Node {
+	n := &Node{
+		key:    key,
+		typ:    key,
+		action: param,
+		next:   make([]*Node, 0),
+	}
+
+	for _, option := range options {
+		option(n)
+	}
+
+	return n
+}
+
+func NewWorkflowNode(key string, workflow string, storage Storage, options ...NodeOption) *Node {
+	n := NewNodeWithContext(key, nil, options...)
+	n.workflow = workflow
+	n.storage = storage
+	n.action = n.callWorkflow
+
+	return n
+}
+
+func WithCompensation(compensate contextAction) NodeOption {
+	return func(n *Node) {
+		n.compensate = compensate
+	}
+}
+
+func WithMaxDepth(depth int) NodeOption {
+	return func(n *Node) {
+		n.maxDepth = depth
+	}
+}
+
+func (n *Node) callWorkflow(ctx context.Context, param map[string][]byte) ([]byte, error) {
+	stack, _ := ctx.Value(callStackKey{}).([]string)
+	if contains(stack, n.workflow) {
+		return nil, &RecursionError{Node: n.key, Stack: append(stack[:len(stack):len(stack)], n.workflow)}
+	}
+
+	maxDepth := n.maxDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxDepth
+	}
+
+	if len(stack) >= maxDepth {
+		return nil, fmt.Errorf("workflow depth limit %d exceeded at node '%s'", maxDepth, n.key)
+	}
+
+	w, err := n.storage.Get(n.workflow)
+	if err != nil {
+		return nil, err
+	}
+
+	if w.root == nil {
+		return nil, fmt.Errorf("workflow '%s' has no edges", w.key)
+	}
+
+	ctx = context.WithValue(ctx, callStackKey{}, append(stack[:len(stack):len(stack)], w.key))
+	ctx = context.WithValue(ctx, boundaryKey{}, (*Node)(nil))
+	if w.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, w.timeout, errRunTimeout)
+		defer cancel()
+	}
+
+	event := RunEvent{Workflow: w.key, Input: param["data"], Status: RunRunning}
+	if state := runStateFrom(ctx); state != nil {
+		event.ID = state.run.ID
+	}
+
+	started := time.Now()
+	w.runStart(ctx, event)
+	res, err := w.dispatch(withScope(ctx, n.key+"/"), w.root, param["data"])
+	event.Result, event.Err, event.Status, event.Duration = res, err, runStatus(err), time.Since(started)
+	w.runEnd(ctx, event)
+
+	return res, err
+}
+
+func (n *Node) GetKey() string {
+	return n.key
+}
+
+func (n *Node) GetType() string {
+	return n.typ
+}
+
+func (n *Node) Trigger(param map[string][]byte) ([]byte, error) {
+	return n.TriggerContext(context.Background(), param)
 }
 
-func (n *node) Trigger(param map[string][]byte) ([]byte, error) {
-	return n.action(param)
+func (n *Node) TriggerContext(ctx context.Context, param map[string][]byte) ([]byte, error) {
+	return n.action(ctx, param)
 }
@@ -0,0 +1,1184 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var discard = NewSlogLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+func newTestWorkflow(name string, options ...WorkflowOption) *Workflow {
+	return NewWorkflow(name, append([]WorkflowOption{WithLogger(discard), WithMetrics(NewMetrics(nil))}, options...)...)
+}
+
+func echoNode(key string) *Node {
+	return NewNode(key, func(param map[string][]byte) ([]byte, error) {
+		return []byte(string(param["data"]) + key), nil
+	})
+}
+
+func failNode(key string) *Node {
+	return NewNode(key, func(param map[string][]byte) ([]byte, error) {
+		return nil, errors.New(key + " failed")
+	})
+}
+
+func newParallelWorkflow(t *testing.T, policy ParallelPolicy, failing ...string) *Workflow {
+	t.Helper()
+
+	branches := make([]*Node, 0, 3)
+	for _, key := range []string{"b1", "b2", "b3"} {
+		n := echoNode(key)
+		for _, f := range failing {
+			if f == key {
+				n = failNode(key)
+			}
+		}
+
+		branches = append(branches, n)
+	}
+
+	start := echoNode("start")
+	aggregate := NewNode("aggregate", func(param map[string][]byte) ([]byte, error) {
+		return json.Marshal(map[string]string{
+			"data":     string(param["data"]),
+			"branches": string(param["branches"]),
+			"errors":   string(param["errors"]),
+			"settled":  string(param["settled"]),
+		})
+	})
+
+	w := newTestWorkflow("parallel")
+	w.AddNode(append(branches, start, aggregate)...)
+	if err := w.AddParallelEdgeWithPolicy(start, aggregate, policy, branches...); err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+func executeParallel(t *testing.T, w *Workflow) (map[string]string, []string, error) {
+	t.Helper()
+
+	res, err := w.Execute([]byte("x"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out := map[string]string{}
+	if err := json.Unmarshal(res, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	branches := []string{}
+	if err := json.Unmarshal([]byte(out["branches"]), &branches); err != nil {
+		t.Fatal(err)
+	}
+
+	return out, branches, nil
+}
+
+func TestParallelErrorModes(t *testing.T) {
+	cases := []struct {
+		name         string
+		mode         ErrorMode
+		failing      []string
+		wantErrors   int
+		wantBranches int
+	}{
+		{name: "all succeed", mode: FailFast, wantBranches: 3},
+		{name: "fail fast", mode: FailFast, failing: []string{"b1", "b2"}, wantErrors: 1},
+		{name: "wait all", mode: WaitAll, failing: []string{"b1", "b2"}, wantErrors: 2},
+		{name: "continue on error", mode: ContinueOnError, failing: []string{"b1"}, wantBranches: 2},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			w := newParallelWorkflow(t, ParallelPolicy{OnError: c.mode}, c.failing...)
+
+			out, branches, err := executeParallel(t, w)
+			if c.wantErrors > 0 {
+				parallel := &ParallelError{}
+				if !errors.As(err, &parallel) {
+					t.Fatalf("expected parallel error, got %v", err)
+				}
+
+				if parallel.Node != "start" || len(parallel.Errors) != c.wantErrors {
+					t.Fatalf("expected %d branch errors at start, got %d at %s: %v", c.wantErrors, len(parallel.Errors), parallel.Node, parallel.Errors)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(branches) != c.wantBranches || out["data"] != "xstart" {
+				t.Fatalf("expected %d branches after start, got %v with %q", c.wantBranches, branches, out["data"])
+			}
+
+			if len(c.failing) > 0 {
+				errs := map[string]string{}
+				if err := json.Unmarshal([]byte(out["errors"]), &errs); err != nil {
+					t.Fatal(err)
+				}
+
+				for _, key := range c.failing {
+					if errs[key] != key+" failed" {
+						t.Fatalf("expected error of %s to be passed to the aggregate, got %v", key, errs)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestParallelJoinStrategies(t *testing.T) {
+	cases := []struct {
+		name         string
+		policy       ParallelPolicy
+		failing      []string
+		wantErrors   int
+		wantBranches int
+		wantSettled  map[string]string
+	}{
+		{name: "all", policy: ParallelPolicy{Join: JoinAll}, wantBranches: 3},
+		{name: "first", policy: ParallelPolicy{Join: JoinFirst}, wantBranches: 1},
+		{name: "first after failures", policy: ParallelPolicy{Join: JoinFirst}, failing: []string{"b1", "b2"}, wantBranches: 1},
+		{name: "first with all failing", policy: ParallelPolicy{Join: JoinFirst}, failing: []string{"b1", "b2", "b3"}, wantErrors: 3},
+		{name: "quorum reached", policy: ParallelPolicy{Join: JoinQuorum, Quorum: 2}, failing: []string{"b3"}, wantBranches: 2},
+		{name: "quorum unreachable", policy: ParallelPolicy{Join: JoinQuorum, Quorum: 3}, failing: []string{"b3"}, wantErrors: 1},
+		{
+			name:         "all settled",
+			policy:       ParallelPolicy{Join: JoinAllSettled},
+			failing:      []string{"b2"},
+			wantBranches: 2,
+			wantSettled:  map[string]string{"b1": "fulfilled", "b2": "rejected", "b3": "fulfilled"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			w := newParallelWorkflow(t, c.policy, c.failing...)
+
+			out, branches, err := executeParallel(t, w)
+			if c.wantErrors > 0 {
+				parallel := &ParallelError{}
+				if !errors.As(err, &parallel) {
+					t.Fatalf("expected parallel error, got %v", err)
+				}
+
+				if len(parallel.Errors) != c.wantErrors {
+					t.Fatalf("expected %d branch errors, got %d: %v", c.wantErrors, len(parallel.Errors), parallel.Errors)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(branches) != c.wantBranches {
+				t.Fatalf("expected %d branches, got %v", c.wantBranches, branches)
+			}
+
+			if c.wantSettled != nil {
+				settled := map[string]string{}
+				if err := json.Unmarshal([]byte(out["settled"]), &settled); err != nil {
+					t.Fatal(err)
+				}
+
+				if !reflect.DeepEqual(settled, c.wantSettled) {
+					t.Fatalf("expected %v, got %v", c.wantSettled, settled)
+				}
+			}
+		})
+	}
+}
+
+func TestParallelEdgeValidation(t *testing.T) {
+	cases := []struct {
+		name     string
+		policy   ParallelPolicy
+		branches []string
+		wantErr  string
+	}{
+		{name: "quorum too small", policy: ParallelPolicy{Join: JoinQuorum}, branches: []string{"b1", "b2"}, wantErr: "quorum"},
+		{name: "quorum too large", policy: ParallelPolicy{Join: JoinQuorum, Quorum: 3}, branches: []string{"b1", "b2"}, wantErr: "quorum"},
+		{name: "data branch", branches: []string{"b1", "data"}, wantErr: "reserved"},
+		{name: "errors branch", branches: []string{"errors"}, wantErr: "reserved"},
+		{name: "branches branch", branches: []string{"branches"}, wantErr: "reserved"},
+		{name: "settled branch", branches: []string{"settled"}, wantErr: "reserved"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			start, aggregate := echoNode("start"), echoNode("aggregate")
+			w := newTestWorkflow("parallel")
+			w.AddNode(start, aggregate)
+
+			branches := make([]*Node, 0, len(c.branches))
+			for _, key := range c.branches {
+				branches = append(branches, echoNode(key))
+			}
+			w.AddNode(branches...)
+
+			err := w.AddParallelEdgeWithPolicy(start, aggregate, c.policy, branches...)
+			if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", c.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	slow := func(ctx context.Context, param map[string][]byte) ([]byte, error) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+			return param["data"], nil
+		}
+	}
+
+	cases := []struct {
+		name     string
+		options  []WorkflowOption
+		node     []NodeOption
+		wantNode string
+		wantErr  bool
+	}{
+		{name: "no timeout", node: nil, options: nil},
+		{name: "node timeout", node: []NodeOption{WithNodeTimeout(20 * time.Millisecond)}, wantNode: "slow", wantErr: true},
+		{name: "workflow timeout", options: []WorkflowOption{WithTimeout(20 * time.Millisecond)}, wantNode: "slow", wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fast := func(ctx context.Context, param map[string][]byte) ([]byte, error) {
+				return param["data"], nil
+			}
+
+			start := NewNodeWithContext("start", fast)
+			node := NewNodeWithContext("slow", fast, c.node...)
+			if c.wantErr {
+				node = NewNodeWithContext("slow", slow, c.node...)
+			}
+
+			w := newTestWorkflow("timeout", c.options...)
+			w.AddNode(start, node)
+			if err := w.AddEdge(start, node); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := w.Execute([]byte("x"))
+			if !c.wantErr {
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return
+			}
+
+			timeout := &TimeoutError{}
+			if !errors.As(err, &timeout) {
+				t.Fatalf("expected timeout error, got %v", err)
+			}
+
+			if timeout.Node != c.wantNode {
+				t.Fatalf("expected timeout at %s, got %s", c.wantNode, timeout.Node)
+			}
+
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("expected deadline exceeded, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSwitch(t *testing.T) {
+	cases := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{name: "first case", value: "gold", want: "xstartpremium"},
+		{name: "shared target", value: "silver", want: "xstartpremium"},
+		{name: "other case", value: "bronze", want: "xstartbasic"},
+		{name: "default", value: "unknown", want: "xstartfallback"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			start := echoNode("start")
+			condition := NewNode("tier", func(param map[string][]byte) ([]byte, error) {
+				return []byte(c.value), nil
+			})
+			premium, basic, fallback := echoNode("premium"), echoNode("basic"), echoNode("fallback")
+
+			w := newTestWorkflow("switch")
+			w.AddNode(start, condition, premium, basic, fallback)
+			if err := w.AddSwitchEdge(start, condition, map[string]*Node{"gold": premium, "silver": premium, "bronze": basic}, fallback); err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := w.Execute([]byte("x"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(res) != c.want {
+				t.Fatalf("expected %q, got %q", c.want, res)
+			}
+		})
+	}
+
+	start, condition, target := echoNode("start"), echoNode("condition"), echoNode("target")
+	w := newTestWorkflow("switch")
+	w.AddNode(start, condition, target)
+	if err := w.AddSwitchEdge(start, condition, map[string]*Node{"a": target}, nil); err == nil {
+		t.Fatal("expected switch without default to be rejected")
+	}
+
+	if err := w.AddSwitchEdge(start, condition, nil, target); err == nil {
+		t.Fatal("expected switch without cases to be rejected")
+	}
+}
+
+func TestConditionOutputs(t *testing.T) {
+	cases := []struct {
+		name    string
+		value   string
+		strict  bool
+		invalid bool
+		want    string
+		wantErr bool
+	}{
+		{name: "true", value: "true", want: "xstartyes"},
+		{name: "false", value: "false", want: "xstartno"},
+		{name: "lenient invalid value", value: "maybe", want: "xstartno"},
+		{name: "strict valid value", value: "1", strict: true, want: "xstartyes"},
+		{name: "strict invalid value", value: "maybe", strict: true, wantErr: true},
+		{name: "invalid branch", value: "maybe", invalid: true, want: "xstartinvalid"},
+		{name: "strict invalid branch", value: "maybe", strict: true, invalid: true, want: "xstartinvalid"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			start := echoNode("start")
+			condition := NewNode("condition", func(param map[string][]byte) ([]byte, error) {
+				return []byte(c.value), nil
+			})
+			yes, no, invalid := echoNode("yes"), echoNode("no"), echoNode("invalid")
+
+			options := []WorkflowOption{}
+			if c.strict {
+				options = append(options, WithStrictConditions())
+			}
+
+			w := newTestWorkflow("condition", options...)
+			w.AddNode(start, condition, yes, no, invalid)
+			if err := w.AddConditionalEdge(start, condition, yes, no); err != nil {
+				t.Fatal(err)
+			}
+
+			if c.invalid {
+				if err := w.AddConditionErrorEdge(condition, invalid); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			res, err := w.Execute([]byte("x"))
+			if c.wantErr {
+				conditionErr := &ConditionError{}
+				if !errors.As(err, &conditionErr) {
+					t.Fatalf("expected condition error, got %v", err)
+				}
+
+				if conditionErr.Node != "condition" || conditionErr.Value != c.value {
+					t.Fatalf("unexpected condition error %+v", conditionErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(res) != c.want {
+				t.Fatalf("expected %q, got %q", c.want, res)
+			}
+		})
+	}
+
+	start, target := echoNode("start"), echoNode("target")
+	w := newTestWorkflow("condition")
+	w.AddNode(start, target)
+	if err := w.AddConditionErrorEdge(start, target); err == nil {
+		t.Fatal("expected condition error edge on a plain node to be rejected")
+	}
+}
+
+func TestLoops(t *testing.T) {
+	cases := []struct {
+		name      string
+		mode      LoopMode
+		until     int
+		max       int
+		want      string
+		wantCalls int32
+		wantErr   bool
+	}{
+		{name: "while", mode: While, until: 3, max: 5, want: "xstartbbbdone", wantCalls: 3},
+		{name: "while not entered", mode: While, until: 0, max: 5, want: "xstartdone", wantCalls: 0},
+		{name: "while at limit", mode: While, until: 3, max: 3, want: "xstartbbbdone", wantCalls: 3},
+		{name: "while over limit", mode: While, until: 4, max: 3, wantCalls: 3, wantErr: true},
+		{name: "do until", mode: DoUntil, until: 2, max: 5, want: "xstartbbdone", wantCalls: 2},
+		{name: "do until runs once", mode: DoUntil, until: 0, max: 5, want: "xstartbdone", wantCalls: 1},
+		{name: "do until over limit", mode: DoUntil, until: 4, max: 2, wantCalls: 2, wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			calls := int32(0)
+			body := NewNode("b", func(param map[string][]byte) ([]byte, error) {
+				atomic.AddInt32(&calls, 1)
+
+				return []byte(string(param["data"]) + "b"), nil
+			})
+
+			condition := NewNode("condition", func(param map[string][]byte) ([]byte, error) {
+				done := strings.Count(string(param["data"]), "b") >= c.until
+				if c.mode == While {
+					return []byte(strconv.FormatBool(!done)), nil
+				}
+
+				return []byte(strconv.FormatBool(done)), nil
+			})
+
+			start, after := echoNode("start"), echoNode("done")
+			w := newTestWorkflow("loop")
+			w.AddNode(start, condition, body, after)
+
+			add := w.AddWhileEdge
+			if c.mode == DoUntil {
+				add = w.AddDoUntilEdge
+			}
+
+			if err := add(start, condition, body, c.max); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := w.AddEdge(condition, after); err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := w.Execute([]byte("x"))
+			if calls != c.wantCalls {
+				t.Fatalf("expected body to run %d times, got %d", c.wantCalls, calls)
+			}
+
+			if c.wantErr {
+				limit := &LoopLimitError{}
+				if !errors.As(err, &limit) {
+					t.Fatalf("expected loop limit error, got %v", err)
+				}
+
+				if limit.Node != "condition" || limit.MaxIterations != c.max {
+					t.Fatalf("unexpected loop limit error %+v", limit)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(res) != c.want {
+				t.Fatalf("expected %q, got %q", c.want, res)
+			}
+		})
+	}
+}
+
+func TestCycles(t *testing.T) {
+	cases := []struct {
+		name    string
+		build   func(w *Workflow, n map[string]*Node) error
+		wantErr bool
+	}{
+		{
+			name: "back edge to root",
+			build: func(w *Workflow, n map[string]*Node) error {
+				return errors.Join(w.AddEdge(n["a"], n["b"]), w.AddEdge(n["b"], n["a"]))
+			},
+			wantErr: true,
+		},
+		{
+			name: "back edge inside the graph",
+			build: func(w *Workflow, n map[string]*Node) error {
+				return errors.Join(w.AddEdge(n["a"], n["b"]), w.AddEdge(n["b"], n["c"]), w.AddEdge(n["c"], n["d"]), w.AddEdge(n["d"], n["b"]))
+			},
+			wantErr: true,
+		},
+		{
+			name: "self edge",
+			build: func(w *Workflow, n map[string]*Node) error {
+				return errors.Join(w.AddEdge(n["a"], n["b"]), w.AddEdge(n["c"], n["c"]))
+			},
+			wantErr: true,
+		},
+		{
+			name: "branch back to condition",
+			build: func(w *Workflow, n map[string]*Node) error {
+				return errors.Join(w.AddEdge(n["c"], n["b"]), w.AddConditionalEdge(n["a"], n["b"], n["c"], n["d"]))
+			},
+			wantErr: true,
+		},
+		{
+			name: "loop body back to condition",
+			build: func(w *Workflow, n map[string]*Node) error {
+				return errors.Join(w.AddWhileEdge(n["a"], n["b"], n["c"], 3), w.AddEdge(n["c"], n["b"]))
+			},
+			wantErr: true,
+		},
+		{
+			name: "error handler back to failed node",
+			build: func(w *Workflow, n map[string]*Node) error {
+				return errors.Join(w.AddEdge(n["a"], n["b"]), w.AddErrorEdge(n["b"], n["c"]), w.AddEdge(n["c"], n["b"]))
+			},
+			wantErr: true,
+		},
+		{
+			name: "branches joining again",
+			build: func(w *Workflow, n map[string]*Node) error {
+				return errors.Join(w.AddConditionalEdge(n["a"], n["b"], n["c"], n["d"]), w.AddEdge(n["c"], n["e"]), w.AddEdge(n["d"], n["e"]))
+			},
+		},
+		{
+			name: "declared loop",
+			build: func(w *Workflow, n map[string]*Node) error {
+				return errors.Join(w.AddDoUntilEdge(n["a"], n["b"], n["c"], 3), w.AddEdge(n["c"], n["d"]), w.AddEdge(n["b"], n["e"]))
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			nodes := map[string]*Node{}
+			w := newTestWorkflow("cycles")
+			for _, key := range []string{"a", "b", "c", "d", "e"} {
+				nodes[key] = echoNode(key)
+				w.AddNode(nodes[key])
+			}
+
+			err := c.build(w, nodes)
+			if c.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "circular") {
+					t.Fatalf("expected circular error, got %v", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := w.Export(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestMap(t *testing.T) {
+	cases := []struct {
+		name        string
+		items       string
+		concurrency int
+		want        string
+		wantMax     int32
+	}{
+		{name: "unbounded", items: `["a","b","c","d"]`, want: `["A","B","C","D"]`, wantMax: 4},
+		{name: "limited", items: `["a","b","c","d","e","f"]`, concurrency: 2, want: `["A","B","C","D","E","F"]`, wantMax: 2},
+		{name: "sequential", items: `["a","b","c"]`, concurrency: 1, want: `["A","B","C"]`, wantMax: 1},
+		{name: "json items", items: `[{"v":1},2]`, want: `["{\"V\":1}","2"]`, wantMax: 2},
+		{name: "empty", items: `[]`, want: `[]`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			running, max := int32(0), int32(0)
+			splitter := NewNode("splitter", func(param map[string][]byte) ([]byte, error) {
+				return []byte(c.items), nil
+			})
+			mapper := NewNode("mapper", func(param map[string][]byte) ([]byte, error) {
+				current := atomic.AddInt32(&running, 1)
+				defer atomic.AddInt32(&running, -1)
+				for {
+					seen := atomic.LoadInt32(&max)
+					if current <= seen || atomic.CompareAndSwapInt32(&max, seen, current) {
+						break
+					}
+				}
+
+				delay := time.Millisecond
+				if i := strings.Index("abcdef", string(param["data"])); i >= 0 {
+					delay = time.Duration(6-i) * 5 * time.Millisecond
+				}
+				time.Sleep(delay)
+
+				return []byte(strings.ToUpper(string(param["data"]))), nil
+			})
+			reducer := NewNode("reducer", func(param map[string][]byte) ([]byte, error) {
+				if string(param["data"]) != "xstart" {
+					return nil, errors.New("reducer did not receive the map input")
+				}
+
+				return param["items"], nil
+			})
+
+			start := echoNode("start")
+			w := newTestWorkflow("map")
+			w.AddNode(start, splitter, mapper, reducer)
+			if err := w.AddMapEdge(start, splitter, mapper, reducer, c.concurrency); err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := w.Execute([]byte("x"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(res) != c.want {
+				t.Fatalf("expected %s, got %s", c.want, res)
+			}
+
+			if max != c.wantMax {
+				t.Fatalf("expected at most %d concurrent items, got %d", c.wantMax, max)
+			}
+		})
+	}
+}
+
+func TestMultiStepBranches(t *testing.T) {
+	start, aggregate := echoNode("start"), NewNode("aggregate", func(param map[string][]byte) ([]byte, error) {
+		return json.Marshal(map[string]string{"b1": string(param["b1"]), "b2": string(param["b2"]), "b3": string(param["b3"])})
+	})
+	b1, b1next, b1last := echoNode("b1"), echoNode("b1next"), echoNode("b1last")
+	b2, c1, c2, inner := echoNode("b2"), echoNode("c1"), echoNode("c2"), NewNode("inner", func(param map[string][]byte) ([]byte, error) {
+		return []byte(string(param["c1"]) + "+" + string(param["c2"])), nil
+	})
+	b3, condition, yes, no := echoNode("b3"), NewNode("condition", func(param map[string][]byte) ([]byte, error) {
+		return []byte("false"), nil
+	}), echoNode("yes"), echoNode("no")
+
+	w := newTestWorkflow("branches")
+	w.AddNode(start, aggregate, b1, b1next, b1last, b2, c1, c2, inner, b3, condition, yes, no)
+	err := errors.Join(
+		w.AddParallelEdge(start, aggregate, b1, b2, b3),
+		w.AddEdge(b1, b1next),
+		w.AddEdge(b1next, b1last),
+		w.AddParallelEdge(b2, inner, c1, c2),
+		w.AddConditionalEdge(b3, condition, yes, no),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := w.Execute([]byte("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := map[string]string{}
+	if err := json.Unmarshal(res, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"b1": "xstartb1b1nextb1last", "b2": "xstartb2c1+xstartb2c2", "b3": "xstartb3no"}
+	if !reflect.DeepEqual(out, want) {
+		t.Fatalf("expected %v, got %v", want, out)
+	}
+
+	dot, err := w.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, edge := range []string{`"B1last" -> "Aggregate"`, `"Inner" -> "Aggregate"`, `"No" -> "Aggregate"`, `"Yes" -> "Aggregate"`} {
+		if !strings.Contains(string(dot), edge) {
+			t.Fatalf("expected export to contain %s:\n%s", edge, dot)
+		}
+	}
+
+	if strings.Contains(string(dot), `"B1" -> "Aggregate"`) {
+		t.Fatalf("expected the first branch step not to join the aggregate:\n%s", dot)
+	}
+}
+
+func newSubWorkflow(t *testing.T, storage Storage, name string, nodes ...*Node) *Workflow {
+	t.Helper()
+
+	w := newTestWorkflow(name)
+	w.AddNode(nodes...)
+	for i := 1; i < len(nodes); i++ {
+		if err := w.AddEdge(nodes[i-1], nodes[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := storage.Save(w); err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+func TestSubWorkflow(t *testing.T) {
+	slow := NewNodeWithContext("slow", func(ctx context.Context, param map[string][]byte) ([]byte, error) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+			return param["data"], nil
+		}
+	})
+
+	cases := []struct {
+		name    string
+		build   func(storage Storage) *Workflow
+		want    string
+		wantErr func(err error) bool
+	}{
+		{
+			name: "call",
+			build: func(storage Storage) *Workflow {
+				newSubWorkflow(t, storage, "child", echoNode("a"), echoNode("b"))
+
+				return newSubWorkflow(t, storage, "parent", echoNode("start"), NewWorkflowNode("call", "child", storage), echoNode("end"))
+			},
+			want: "xstartabend",
+		},
+		{
+			name: "recursion",
+			build: func(storage Storage) *Workflow {
+				newSubWorkflow(t, storage, "child", echoNode("a"), NewWorkflowNode("back", "parent", storage))
+
+				return newSubWorkflow(t, storage, "parent", echoNode("start"), NewWorkflowNode("call", "child", storage))
+			},
+			wantErr: func(err error) bool {
+				recursion := &RecursionError{}
+
+				return errors.As(err, &recursion) && recursion.Node == "back" && reflect.DeepEqual(recursion.Stack, []string{"parent", "child", "parent"})
+			},
+		},
+		{
+			name: "depth limit",
+			build: func(storage Storage) *Workflow {
+				newSubWorkflow(t, storage, "leaf", echoNode("a"), echoNode("b"))
+				newSubWorkflow(t, storage, "child", echoNode("a"), NewWorkflowNode("deeper", "leaf", storage, WithMaxDepth(2)))
+
+				return newSubWorkflow(t, storage, "parent", echoNode("start"), NewWorkflowNode("call", "child", storage, WithMaxDepth(2)))
+			},
+			wantErr: func(err error) bool {
+				nodeErr := &NodeError{}
+
+				return errors.As(err, &nodeErr) && nodeErr.Node == "call" && strings.Contains(err.Error(), "depth limit 2 exceeded at node 'deeper'")
+			},
+		},
+		{
+			name: "missing workflow",
+			build: func(storage Storage) *Workflow {
+				return newSubWorkflow(t, storage, "parent", echoNode("start"), NewWorkflowNode("call", "missing", storage))
+			},
+			wantErr: func(err error) bool {
+				return strings.Contains(err.Error(), "workflow 'missing' not found")
+			},
+		},
+		{
+			name: "child timeout",
+			build: func(storage Storage) *Workflow {
+				child := newTestWorkflow("child", WithTimeout(20*time.Millisecond))
+				a := echoNode("a")
+				child.AddNode(a, slow)
+				if err := child.AddEdge(a, slow); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := storage.Save(child); err != nil {
+					t.Fatal(err)
+				}
+
+				return newSubWorkflow(t, storage, "parent", echoNode("start"), NewWorkflowNode("call", "child", storage))
+			},
+			wantErr: func(err error) bool {
+				timeout := &TimeoutError{}
+
+				return errors.As(err, &timeout) && timeout.Node == "slow" && timeout.Timeout == 20*time.Millisecond
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			w := c.build(NewInMemoryStorage())
+
+			res, err := w.Execute([]byte("x"))
+			if c.wantErr != nil {
+				if err == nil || !c.wantErr(err) {
+					t.Fatalf("unexpected error %v", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(res) != c.want {
+				t.Fatalf("expected %q, got %q", c.want, res)
+			}
+		})
+	}
+}
+
+func TestSubWorkflowHooks(t *testing.T) {
+	storage := NewInMemoryStorage()
+	events := []string{}
+	hooks := Hooks{
+		OnRunStart: func(ctx context.Context, event RunEvent) {
+			events = append(events, "start "+event.Workflow)
+		},
+		OnRunEnd: func(ctx context.Context, event RunEvent) {
+			events = append(events, "end "+event.Workflow+" "+string(event.Status)+" "+string(event.Result))
+		},
+	}
+
+	a, b := echoNode("a"), echoNode("b")
+	child := newTestWorkflow("child", WithHooks(hooks))
+	child.AddNode(a, b)
+	if err := child.AddEdge(a, b); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.Save(child); err != nil {
+		t.Fatal(err)
+	}
+
+	parent := newSubWorkflow(t, storage, "parent", echoNode("start"), NewWorkflowNode("call", "child", storage))
+	if _, err := parent.Execute([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"start child", "end child completed xstartab"}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("expected %v, got %v", want, events)
+	}
+}
+
+func TestSubWorkflowExport(t *testing.T) {
+	storage := NewInMemoryStorage()
+	newSubWorkflow(t, storage, "child", echoNode("a"), NewWorkflowNode("back", "parent", storage))
+	w := newSubWorkflow(t, storage, "parent", echoNode("start"), NewWorkflowNode("call", "child", storage))
+
+	cases := []struct {
+		name     string
+		options  []ExportOption
+		want     []string
+		unwanted []string
+	}{
+		{
+			name:     "collapsed",
+			want:     []string{`"Start" -> "Call"`},
+			unwanted: []string{"subgraph", `"Call / A"`},
+		},
+		{
+			name:     "expanded",
+			options:  []ExportOption{ExpandWorkflows()},
+			want:     []string{`subgraph "cluster_Call"`, `label="Child"`, `"Call" -> "Call / A"`, `"Call / A" -> "Call / Back"`},
+			unwanted: []string{`"Call / Back / Start"`},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dot, err := w.Export(c.options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, s := range c.want {
+				if !strings.Contains(string(dot), s) {
+					t.Fatalf("expected export to contain %s:\n%s", s, dot)
+				}
+			}
+
+			for _, s := range c.unwanted {
+				if strings.Contains(string(dot), s) {
+					t.Fatalf("expected export not to contain %s:\n%s", s, dot)
+				}
+			}
+		})
+	}
+}
+
+func TestErrorEdges(t *testing.T) {
+	handler := func(key string) *Node {
+		return NewNode(key, func(param map[string][]byte) ([]byte, error) {
+			return []byte(string(param["node"]) + ": " + string(param["error"])), nil
+		})
+	}
+
+	cases := []struct {
+		name    string
+		options []WorkflowOption
+		build   func(w *Workflow) error
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "node error edge",
+			build: func(w *Workflow) error {
+				start, bad, next, h := echoNode("start"), failNode("bad"), echoNode("next"), handler("handler")
+				w.AddNode(start, bad, next, h)
+
+				return errors.Join(w.AddEdge(start, bad), w.AddEdge(bad, next), w.AddErrorEdge(bad, h))
+			},
+			want: "bad: bad failed",
+		},
+		{
+			name: "error edge on leaf before outgoing edge",
+			build: func(w *Workflow) error {
+				start, leaf, next, h := echoNode("start"), echoNode("leaf"), failNode("next"), handler("handler")
+				w.AddNode(start, leaf, next, h)
+
+				return errors.Join(w.AddEdge(start, leaf), w.AddErrorEdge(leaf, h), w.AddEdge(leaf, next))
+			},
+			wantErr: true,
+		},
+		{
+			name: "node error edge ignores downstream failures",
+			build: func(w *Workflow) error {
+				start, mid, bad, h := echoNode("start"), echoNode("mid"), failNode("bad"), handler("handler")
+				w.AddNode(start, mid, bad, h)
+
+				return errors.Join(w.AddEdge(start, mid), w.AddEdge(mid, bad), w.AddErrorEdge(mid, h))
+			},
+			wantErr: true,
+		},
+		{
+			name: "region catches parallel branch",
+			build: func(w *Workflow) error {
+				start, b1, b2, aggregate, h := echoNode("start"), echoNode("b1"), failNode("b2"), echoNode("aggregate"), handler("handler")
+				w.AddNode(start, b1, b2, aggregate, h)
+
+				return errors.Join(w.AddParallelEdge(start, aggregate, b1, b2), w.AddErrorRegionEdge(start, h))
+			},
+			want: "start: parallel execution of 'start' failed: 'b2': b2 failed",
+		},
+		{
+			name: "parallel node error edge",
+			build: func(w *Workflow) error {
+				start, b1, b2, b3, aggregate, h := echoNode("start"), failNode("b1"), failNode("b2"), failNode("b3"), echoNode("aggregate"), handler("handler")
+				w.AddNode(start, b1, b2, b3, aggregate, h)
+
+				return errors.Join(w.AddParallelEdgeWithPolicy(start, aggregate, ParallelPolicy{OnError: WaitAll}, b1, b2, b3), w.AddErrorEdge(start, h))
+			},
+			want: "start: parallel execution of 'start' failed: 'b1': b1 failed; 'b2': b2 failed; 'b3': b3 failed",
+		},
+		{
+			name: "loop condition error edge",
+			build: func(w *Workflow) error {
+				start, condition, body, h := echoNode("start"), failNode("condition"), echoNode("body"), handler("handler")
+				w.AddNode(start, condition, body, h)
+
+				return errors.Join(w.AddWhileEdge(start, condition, body, 3), w.AddErrorEdge(condition, h))
+			},
+			want: "condition: condition failed",
+		},
+		{
+			name:    "node error edge ignores downstream condition errors",
+			options: []WorkflowOption{WithStrictConditions()},
+			build: func(w *Workflow) error {
+				start, mid, condition, yes, no, h := echoNode("start"), echoNode("mid"), echoNode("condition"), echoNode("yes"), echoNode("no"), handler("handler")
+				w.AddNode(start, mid, condition, yes, no, h)
+
+				return errors.Join(w.AddEdge(start, mid), w.AddConditionalEdge(mid, condition, yes, no), w.AddErrorEdge(mid, h))
+			},
+			wantErr: true,
+		},
+		{
+			name:    "strict condition error edge",
+			options: []WorkflowOption{WithStrictConditions()},
+			build: func(w *Workflow) error {
+				start, mid, condition, yes, no, h, other := echoNode("start"), echoNode("mid"), echoNode("condition"), echoNode("yes"), echoNode("no"), handler("handler"), handler("other")
+				w.AddNode(start, mid, condition, yes, no, h, other)
+
+				return errors.Join(w.AddEdge(start, mid), w.AddConditionalEdge(mid, condition, yes, no), w.AddErrorEdge(condition, h), w.AddErrorEdge(mid, other))
+			},
+			want: "condition: condition node 'condition' returned non boolean value 'xstartmidcondition'",
+		},
+		{
+			name: "loop limit error edge",
+			build: func(w *Workflow) error {
+				start, body, h := echoNode("start"), echoNode("body"), handler("handler")
+				condition := NewNode("condition", func(param map[string][]byte) ([]byte, error) {
+					return []byte("true"), nil
+				})
+				w.AddNode(start, condition, body, h)
+
+				return errors.Join(w.AddWhileEdge(start, condition, body, 2), w.AddErrorEdge(condition, h))
+			},
+			want: "condition: loop 'condition' did not finish within 2 iterations",
+		},
+		{
+			name: "node error edge ignores downstream loop limit",
+			build: func(w *Workflow) error {
+				start, mid, body, h := echoNode("start"), echoNode("mid"), echoNode("body"), handler("handler")
+				condition := NewNode("condition", func(param map[string][]byte) ([]byte, error) {
+					return []byte("true"), nil
+				})
+				w.AddNode(start, mid, condition, body, h)
+
+				return errors.Join(w.AddEdge(start, mid), w.AddWhileEdge(mid, condition, body, 2), w.AddErrorEdge(mid, h))
+			},
+			wantErr: true,
+		},
+		{
+			name: "splitter error edge",
+			build: func(w *Workflow) error {
+				start, splitter, mapper, reducer, h, other := echoNode("start"), echoNode("splitter"), echoNode("mapper"), echoNode("reducer"), handler("handler"), handler("other")
+				w.AddNode(start, splitter, mapper, reducer, h, other)
+
+				return errors.Join(w.AddMapEdge(start, splitter, mapper, reducer, 0), w.AddErrorEdge(splitter, h), w.AddErrorEdge(start, other))
+			},
+			want: "splitter: splitter node 'splitter' must return a json array",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			w := newTestWorkflow("errors", c.options...)
+			if err := c.build(w); err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 10; i++ {
+				res, err := w.Execute([]byte("x"))
+				if c.wantErr {
+					if err == nil {
+						t.Fatalf("expected error, got %s", res)
+					}
+
+					continue
+				}
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !strings.HasPrefix(string(res), c.want) {
+					t.Fatalf("expected %q, got %q", c.want, res)
+				}
+			}
+		})
+	}
+}
+
+func TestPanicRecovery(t *testing.T) {
+	panicking := func(key string) *Node {
+		return NewNode(key, func(param map[string][]byte) ([]byte, error) {
+			var items []string
+
+			return []byte(items[len(param["data"])]), nil
+		})
+	}
+
+	cases := []struct {
+		name     string
+		build    func(w *Workflow, start *Node) error
+		wantNode string
+	}{
+		{
+			name: "sequential node",
+			build: func(w *Workflow, start *Node) error {
+				bad := panicking("bad")
+				w.AddNode(start, bad)
+
+				return w.AddEdge(start, bad)
+			},
+			wantNode: "bad",
+		},
+		{
+			name: "parallel branch",
+			build: func(w *Workflow, start *Node) error {
+				b1, b2, aggregate := echoNode("b1"), panicking("b2"), echoNode("aggregate")
+				w.AddNode(start, b1, b2, aggregate)
+
+				return w.AddParallelEdgeWithPolicy(start, aggregate, ParallelPolicy{OnError: WaitAll}, b1, b2)
+			},
+			wantNode: "b2",
+		},
+		{
+			name: "map item",
+			build: func(w *Workflow, start *Node) error {
+				splitter := NewNode("splitter", func(param map[string][]byte) ([]byte, error) {
+					return []byte(`["a","b"]`), nil
+				})
+				mapper, reducer := panicking("mapper"), echoNode("reducer")
+				w.AddNode(start, splitter, mapper, reducer)
+
+				return w.AddMapEdge(start, splitter, mapper, reducer, 2)
+			},
+			wantNode: "mapper",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			compensated := atomic.Bool{}
+			start := NewNode("start", func(param map[string][]byte) ([]byte, error) {
+				return param["data"], nil
+			}, WithCompensation(func(ctx context.Context, param map[string][]byte) ([]byte, error) {
+				compensated.Store(true)
+
+				return nil, nil
+			}))
+
+			w := newTestWorkflow("panic")
+			if err := c.build(w, start); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := w.Execute([]byte("x"))
+			recovered := &PanicError{}
+			if !errors.As(err, &recovered) {
+				t.Fatalf("expected panic error, got %v", err)
+			}
+
+			if recovered.Node != c.wantNode || len(recovered.Stack) == 0 {
+				t.Fatalf("unexpected panic error %+v", recovered)
+			}
+
+			if !compensated.Load() {
+				t.Fatal("expected completed node to be compensated")
+			}
+		})
+	}
+}